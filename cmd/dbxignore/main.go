@@ -1,25 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	cli "github.com/urfave/cli/v3"
 	
+	"github.com/gghcode/dropbox-ignore-daemon/internal/audit"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/logging"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/lsp"
 	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
 	"github.com/gghcode/dropbox-ignore-daemon/internal/poller"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/report"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/retry"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/revert"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/scaffold"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/snapshot"
 	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/symlink"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/suggest"
 	"github.com/gghcode/dropbox-ignore-daemon/internal/watcher"
 	"github.com/gghcode/dropbox-ignore-daemon/internal/xattr"
 )
@@ -46,13 +61,96 @@ var commonFlags = []cli.Flag{
 	&cli.BoolFlag{
 		Name:    "verbose",
 		Aliases: []string{"v"},
-		Usage:   "Enable verbose logging",
+		Usage:   "Enable debug logging",
+	},
+	gitIgnoreFlag,
+}
+
+// gitIgnoreFlag opts in to honoring .gitignore rules inside git repositories
+var gitIgnoreFlag = &cli.BoolFlag{
+	Name:  "gitignore",
+	Usage: "Also ignore untracked paths matched by .gitignore rules inside git repositories",
+}
+
+// logFlags configure the logging of long-running commands
+var logFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "log-level",
+		Usage: "Minimum level to log: debug, info, warn or error",
+		Value: "info",
+	},
+	&cli.StringFlag{
+		Name:  "log-format",
+		Usage: "Log format: " + strings.Join(logging.Formats, ", "),
+		Value: "text",
+	},
+	&cli.BoolFlag{
+		Name:  "log-journald",
+		Usage: "Also send logs to the systemd journal",
+	},
+	&cli.StringFlag{
+		Name:  "log-syslog",
+		Usage: "Also send logs to syslog: local, or an address such as udp://host:514",
+	},
+}
+
+// makeWritableFlag opts in to briefly making read-only paths writable to
+// change their attribute
+var makeWritableFlag = &cli.BoolFlag{
+	Name:  "make-writable",
+	Usage: "Briefly add owner write permission to read-only paths that reject the ignore attribute",
+}
+
+// scanFlags size the walker of long-running commands
+var scanFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of directories to read at once during scans",
+		Value: runtime.NumCPU(),
+	},
+	&cli.BoolFlag{
+		Name:  "low-resource",
+		Usage: "Scan on a single thread to keep CPU and memory use low, e.g. on laptops",
+	},
+}
+
+// symlinksFlag sets how symbolic links are matched and marked
+var symlinksFlag = &cli.StringFlag{
+	Name:  "symlinks",
+	Usage: "How to treat symbolic links: follow (mark the target if it is within the root), mark (mark the link itself) or skip",
+	Value: string(symlink.Follow),
+}
+
+// retryQueueFlag is where paths whose attribute could not be written are
+// kept until the daemon retries them
+var retryQueueFlag = &cli.StringFlag{
+	Name:  "retry-queue",
+	Usage: "Keep paths whose ignore attribute could not be written in this file until they are retried; empty keeps them in memory",
+	Value: defaultRetryQueue(),
+}
+
+// scanRetryQueueFlag is the retry queue of one-off scans. It has no default:
+// the daemon rewrites its queue file from memory, so a scan writing the same
+// file while it runs would lose entries.
+var scanRetryQueueFlag = &cli.StringFlag{
+	Name:  "retry-queue",
+	Usage: "Leave paths whose ignore attribute could not be written in this file for the daemon to retry; only while the daemon is stopped",
+}
+
+var auditFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "audit-log",
+		Usage: "Append every attribute change to this JSON Lines file",
+	},
+	&cli.StringFlag{
+		Name:  "audit-max-size",
+		Usage: "Rotate the audit log when it reaches this size",
+		Value: "10M",
 	},
 }
 
 func main() {
 	// Performance optimizations
-	runtime.GOMAXPROCS(1)
 	debug.SetGCPercent(400)
 	
 	app := &cli.Command{
@@ -63,21 +161,207 @@ func main() {
 			{
 				Name:  "serve",
 				Usage: "Run the daemon",
-				Flags: append(commonFlags,
+				Flags: append(slices.Concat(commonFlags, scanFlags, logFlags, auditFlags), retryQueueFlag, makeWritableFlag, symlinksFlag,
 					&cli.DurationFlag{
 						Name:  "scan-interval",
 						Usage: "Polling interval",
 						Value: 5 * time.Minute,
 					},
+					&cli.StringFlag{
+						Name:  "metrics-addr",
+						Usage: "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9090",
+					},
 				),
 				Action: serve,
 			},
 			{
 				Name:   "scan",
 				Usage:  "Run a one-time scan",
-				Flags:  commonFlags,
+				Flags:  append(slices.Concat(commonFlags, scanFlags, logFlags, auditFlags), scanRetryQueueFlag, makeWritableFlag, symlinksFlag),
 				Action: scan,
 			},
+			{
+				Name:      "check",
+				Usage:     "Explain whether paths would be ignored",
+				ArgsUsage: "<path>...",
+				Flags:     []cli.Flag{gitIgnoreFlag, symlinksFlag},
+				Action:    check,
+			},
+			{
+				Name:      "init",
+				Usage:     "Write a .dropboxignore with rules for the project types found in a directory",
+				ArgsUsage: "[dir]",
+				Flags:     []cli.Flag{commonFlags[1]},
+				Action:    initIgnoreFile,
+			},
+			{
+				Name:   "lint",
+				Usage:  "Check ignore files for invalid, duplicate, shadowed and dead rules",
+				Flags:  commonFlags[:1], // Only root flag
+				Action: lint,
+			},
+			{
+				Name:      "test",
+				Usage:     "Check the expect-ignored and expect-synced assertions of ignore files",
+				ArgsUsage: "[file]...",
+				Flags:     []cli.Flag{commonFlags[0], gitIgnoreFlag},
+				Action:    test,
+			},
+			{
+				Name:   "stats",
+				Usage:  "Scan without changes and show what each rule keeps out of Dropbox",
+				Flags:  []cli.Flag{commonFlags[0], gitIgnoreFlag},
+				Action: stats,
+			},
+			{
+				Name:  "report",
+				Usage: "List ignored paths with their size, rule and when they were marked",
+				Flags: []cli.Flag{
+					commonFlags[0],
+					gitIgnoreFlag,
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Output format: " + strings.Join(report.Formats, ", "),
+						Value:   "table",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Write the report to a file instead of stdout",
+					},
+					&cli.StringFlag{
+						Name:  "audit-log",
+						Usage: "Audit log to take the time of each mark from instead of the inode change time",
+					},
+				},
+				Action: writeReport,
+			},
+			{
+				Name:  "suggest",
+				Usage: "Find large generated directories and propose rules for them",
+				Flags: []cli.Flag{
+					commonFlags[0],
+					gitIgnoreFlag,
+					&cli.StringFlag{
+						Name:  "min-size",
+						Usage: "Smallest directory worth suggesting, e.g. 50M",
+						Value: "10M",
+					},
+					&cli.BoolFlag{
+						Name:    "interactive",
+						Aliases: []string{"i"},
+						Usage:   "Ask before appending each suggestion to its ignore file",
+					},
+					&cli.BoolFlag{
+						Name:  "patch",
+						Usage: "Print the suggestions as a unified diff instead",
+					},
+				},
+				Action: suggestRules,
+			},
+			{
+				Name:  "adopt",
+				Usage: "Turn paths already ignored by hand into .dropboxignore rules",
+				Flags: []cli.Flag{
+					commonFlags[0],
+					gitIgnoreFlag,
+					&cli.StringFlag{
+						Name:  "exclude-list",
+						Usage: "Also adopt the directories in a saved `dropbox exclude list` output file",
+					},
+					&cli.BoolFlag{
+						Name:  "write",
+						Usage: "Append the rules to their ignore files",
+					},
+					&cli.BoolFlag{
+						Name:  "patch",
+						Usage: "Print the rules as a unified diff instead",
+					},
+				},
+				Action: adopt,
+			},
+			{
+				Name:  "revert",
+				Usage: "Clear ignore attributes the daemon set, selected by time, rule or path; stop the daemon first",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "audit-log",
+						Usage:    "Audit log the daemon recorded its changes in",
+						Required: true,
+					},
+					auditFlags[1],
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only marks made after a date, RFC 3339 time or age such as 2h",
+					},
+					&cli.StringFlag{
+						Name:  "rule",
+						Usage: "Only marks made by a rule, given as a pattern or file:line",
+					},
+					&cli.StringFlag{
+						Name:  "path",
+						Usage: "Only marks on a path or below it",
+					},
+					commonFlags[1],
+					makeWritableFlag,
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Do not ask for confirmation",
+					},
+				},
+				Action: revertMarks,
+			},
+			{
+				Name:  "snapshot",
+				Usage: "Save or restore which paths carry the ignore attribute",
+				Commands: []*cli.Command{
+					{
+						Name:      "save",
+						Usage:     "Write the ignore state of the root to a snapshot file",
+						ArgsUsage: "<file>",
+						Flags:     []cli.Flag{commonFlags[0]},
+						Action:    saveSnapshot,
+					},
+					{
+						Name:      "restore",
+						Usage:     "Show how the root differs from a snapshot and reapply it",
+						ArgsUsage: "<file>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "root",
+								Aliases: []string{"r"},
+								Usage:   "Root to restore into (default: the snapshot's root)",
+							},
+							commonFlags[1],
+							makeWritableFlag,
+							&cli.BoolFlag{
+								Name:  "exact",
+								Usage: "Also clear the attribute on paths not in the snapshot",
+							},
+							&cli.BoolFlag{
+								Name:    "yes",
+								Aliases: []string{"y"},
+								Usage:   "Do not ask for confirmation",
+							},
+						},
+						Action: restoreSnapshot,
+					},
+				},
+			},
+			{
+				Name:   "status",
+				Usage:  "Show paths waiting to be retried and paths given up on",
+				Flags:  []cli.Flag{retryQueueFlag},
+				Action: status,
+			},
+			{
+				Name:   "lsp",
+				Usage:  "Run a language server for ignore files on stdin and stdout",
+				Flags:  []cli.Flag{gitIgnoreFlag},
+				Action: serveLSP,
+			},
 			{
 				Name:   "install",
 				Usage:  "Install system service",
@@ -93,15 +377,20 @@ func main() {
 	}
 	
 	if err := app.Run(context.Background(), os.Args); err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 func serve(ctx context.Context, cmd *cli.Command) error {
-	cfg := getConfig(cmd)
+	cfg, err := getConfig(cmd)
+	if err != nil {
+		return err
+	}
+	defer cfg.closeLog.Close()
 	
 	// Create components
-	m, err := matcher.NewMatcher(32)
+	m, err := newMatcher(cfg.gitIgnore, cfg.logger)
 	if err != nil {
 		return fmt.Errorf("failed to create matcher: %w", err)
 	}
@@ -109,24 +398,62 @@ func serve(ctx context.Context, cmd *cli.Command) error {
 	cache := state.NewCache(1 * time.Minute)
 	// StartCleaner now returns a no-op function, so we don't need to defer it
 	
+	auditLog, err := openAuditLog(cmd, cfg.logger)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+	
+	// Metrics are only collected when they are served
+	var dm *daemonMetrics
+	if cmd.String("metrics-addr") != "" {
+		dm = newDaemonMetrics(m, cache)
+	}
+	
+	retries, err := retry.NewQueue(retry.Config{
+		Path:   retryQueuePath(cmd),
+		Logger: logging.For(cfg.logger, logging.Xattr),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load retry queue: %w", err)
+	}
+	dm.addRetryQueue(retries)
+	
 	// Create handler without worker pool
-	handler := createHandler(m, cache, cfg.dryRun, cfg.logger)
+	handler, retryPath := createHandler(handlerConfig{
+		matcher:  m,
+		cache:    cache,
+		auditLog: auditLog,
+		metrics:  dm,
+		retries:  retries,
+		marker:   cfg.marker,
+		symlinks: cfg.symlinks,
+		dryRun:   cfg.dryRun,
+		logger:   cfg.logger,
+	})
 	
 	// Create watcher
 	w, err := watcher.NewWatcher(watcher.Config{
 		Handler: func(event watcher.Event) error {
-			info, err := os.Stat(event.Path)
+			info, err := cfg.symlinks.Stat(event.Path)
+			if errors.Is(err, symlink.ErrEscapesRoot) {
+				cfg.logger.Warn("Skipping symlink that escapes the root", "path", event.Path)
+				return nil
+			}
 			if err != nil {
-				return nil // File might have been deleted
+				return nil // File might have been deleted, or is a link the policy skips
 			}
-			return handler(event.Path, info)
+			return handler(event.Path, fs.FileInfoToDirEntry(info), audit.SourceWatcher, event.Time)
 		},
-		Logger: cfg.logger,
+		Logger: logging.For(cfg.logger, logging.Watcher),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create watcher: %w", err)
 	}
 	defer w.Close()
+	dm.addWatcher(w)
 	
 	// Add watches
 	if err := w.AddRecursive(cfg.root); err != nil {
@@ -137,8 +464,16 @@ func serve(ctx context.Context, cmd *cli.Command) error {
 	p, err := poller.NewPoller(poller.Config{
 		Root:         cfg.root,
 		ScanInterval: cmd.Duration("scan-interval"),
-		Handler:      handler,
-		Logger:       cfg.logger,
+		Handler: func(path string, d fs.DirEntry) error {
+			return handler(path, d, audit.SourcePoller, time.Time{})
+		},
+		Logger:   logging.For(cfg.logger, logging.Poller),
+		OnScan:   dm.observeScan,
+		Symlinks: cfg.symlinks,
+		Workers:  cfg.workers,
+		// Files written in place can start or stop matching size and
+		// time predicates
+		RecheckModified: m.UsesPredicates,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create poller: %w", err)
@@ -153,13 +488,34 @@ func serve(ctx context.Context, cmd *cli.Command) error {
 	
 	var wg sync.WaitGroup
 	
+	// Start metrics listener
+	if addr := cmd.String("metrics-addr"); addr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dm.registry.Serve(ctx, addr, cfg.logger); err != nil {
+				cfg.logger.Error("Metrics server failed", "error", err)
+			}
+		}()
+	}
+	
+	// Start retry queue
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if pending, failed := retries.Len(); pending+failed > 0 {
+			cfg.logger.Info("Loaded retry queue", "pending", pending, "failed", failed)
+		}
+		retries.Run(ctx, retryPath)
+	}()
+	
 	// Start watcher
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		cfg.logger.Printf("Starting filesystem watcher for %s", cfg.root)
+		cfg.logger.Info("Starting filesystem watcher", "root", cfg.root)
 		if err := w.Run(ctx); err != nil && err != context.Canceled {
-			cfg.logger.Printf("Watcher error: %v", err)
+			cfg.logger.Error("Watcher failed", "error", err)
 		}
 	}()
 	
@@ -167,17 +523,17 @@ func serve(ctx context.Context, cmd *cli.Command) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		cfg.logger.Printf("Starting periodic scanner (interval: %v)", cmd.Duration("scan-interval"))
+		cfg.logger.Info("Starting periodic scanner", "interval", cmd.Duration("scan-interval"))
 		if err := p.Run(ctx); err != nil && err != context.Canceled {
-			cfg.logger.Printf("Poller error: %v", err)
+			cfg.logger.Error("Poller failed", "error", err)
 		}
 	}()
 	
 	// Wait for signal
-	cfg.logger.Printf("Dropbox ignore daemon started. Press Ctrl+C to stop.")
+	cfg.logger.Info("Dropbox ignore daemon started. Press Ctrl+C to stop.")
 	select {
 	case <-sigCh:
-		cfg.logger.Println("Shutting down...")
+		cfg.logger.Info("Shutting down")
 		cancel()
 	case <-ctx.Done():
 	}
@@ -188,31 +544,714 @@ func serve(ctx context.Context, cmd *cli.Command) error {
 }
 
 func scan(ctx context.Context, cmd *cli.Command) error {
-	cfg := getConfig(cmd)
+	cfg, err := getConfig(cmd)
+	if err != nil {
+		return err
+	}
+	defer cfg.closeLog.Close()
 	
 	// Create components
-	m, err := matcher.NewMatcher(32)
+	m, err := newMatcher(cfg.gitIgnore, cfg.logger)
 	if err != nil {
 		return fmt.Errorf("failed to create matcher: %w", err)
 	}
 	
 	cache := state.NewCache(1 * time.Minute)
 	
+	auditLog, err := openAuditLog(cmd, cfg.logger)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+	
+	// Failures are counted in memory, or queued in a file for the daemon to
+	// retry if one is given
+	retries, err := retry.NewQueue(retry.Config{
+		Path:   retryQueuePath(cmd),
+		Logger: logging.For(cfg.logger, logging.Xattr),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load retry queue: %w", err)
+	}
+	
 	// Create handler without worker pool
-	handler := createHandler(m, cache, cfg.dryRun, cfg.logger)
+	handler, _ := createHandler(handlerConfig{
+		matcher:  m,
+		cache:    cache,
+		auditLog: auditLog,
+		retries:  retries,
+		marker:   cfg.marker,
+		symlinks: cfg.symlinks,
+		dryRun:   cfg.dryRun,
+		logger:   cfg.logger,
+	})
 	
 	// Create poller for one-time scan
 	p, err := poller.NewPoller(poller.Config{
-		Root:    cfg.root,
-		Handler: handler,
-		Logger:  cfg.logger,
+		Root: cfg.root,
+		Handler: func(path string, d fs.DirEntry) error {
+			return handler(path, d, audit.SourceScan, time.Time{})
+		},
+		Logger:   logging.For(cfg.logger, logging.Poller),
+		Symlinks: cfg.symlinks,
+		Workers:  cfg.workers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create scanner: %w", err)
+	}
+	
+	cfg.logger.Info("Scanning", "root", cfg.root)
+	if err := p.Scan(); err != nil {
+		return err
+	}
+	pending, failed := retries.Len()
+	switch {
+	case pending+failed == 0:
+	case retryQueuePath(cmd) != "":
+		cfg.logger.Warn("Paths queued for the daemon to retry", "pending", pending, "failed", failed, "queue", retryQueuePath(cmd))
+	default:
+		cfg.logger.Warn("Could not write the ignore attribute of some paths, rerun the scan or start the daemon to retry", "paths", pending+failed)
+	}
+	return nil
+}
+
+func check(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() == 0 {
+		return fmt.Errorf("at least one path is required")
+	}
+	
+	policy, err := symlink.ParsePolicy(cmd.String("symlinks"))
+	if err != nil {
+		return err
+	}
+	m, err := matcher.NewMatcherWithConfig(matcher.Config{
+		CacheSize: 32,
+		GitIgnore: cmd.Bool("gitignore"),
+		Symlinks:  symlink.Resolver{Policy: policy},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	for _, arg := range cmd.Args().Slice() {
+		path := expandPath(arg)
+		res, err := m.Explain(path)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", path, err)
+			continue
+		}
+		if !res.Ignored {
+			fmt.Printf("%s: not ignored\n", path)
+			continue
+		}
+		if res.Rule == nil {
+			fmt.Printf("%s: ignored by default: not listed in %s\n", path, res.IncludeFile)
+			continue
+		}
+		fmt.Printf("%s: ignored by %s\n", path, res.Rule.Describe())
+	}
+	return nil
+}
+
+func initIgnoreFile(ctx context.Context, cmd *cli.Command) error {
+	dir := "."
+	if cmd.Args().Len() > 1 {
+		return fmt.Errorf("at most one directory is expected")
+	}
+	if cmd.Args().Len() == 1 {
+		dir = expandPath(cmd.Args().First())
+	}
+	
+	dryRun := cmd.Bool("dry-run")
+	res, err := scaffold.Init(dir, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s: %w", dir, err)
+	}
+	if len(res.Detections) == 0 {
+		fmt.Printf("No known project types found in %s\n", dir)
+		return nil
+	}
+	if res.Hidden != "" && res.Added > 0 {
+		consoleLogger().Warn("Creating an ignore file stops the rules of the one above from applying below it",
+			"file", res.File, "hidden", res.Hidden)
+	}
+	if dryRun {
+		fmt.Print(res.Content)
+		return nil
+	}
+	if res.Added == 0 {
+		fmt.Printf("%s already has the rules for %s\n", res.File, strings.Join(res.Names(), ", "))
+		return nil
+	}
+	fmt.Printf("Added %d rules for %s to %s\n", res.Added, strings.Join(res.Names(), ", "), res.File)
+	return nil
+}
+
+func lint(ctx context.Context, cmd *cli.Command) error {
+	m, err := newMatcher(false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	diags, err := m.Lint(expandPath(cmd.String("root")))
+	if err != nil {
+		return fmt.Errorf("lint failed: %w", err)
+	}
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if len(diags) > 0 {
+		return fmt.Errorf("%d problems found", len(diags))
+	}
+	return nil
+}
+
+func test(ctx context.Context, cmd *cli.Command) error {
+	m, err := newMatcher(cmd.Bool("gitignore"), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	files := cmd.Args().Slice()
+	for i, file := range files {
+		files[i] = expandPath(file)
+	}
+	if len(files) == 0 {
+		files, err = m.FindRuleFiles(expandPath(cmd.String("root")))
+		if err != nil {
+			return fmt.Errorf("failed to find ignore files: %w", err)
+		}
+	}
+	
+	checked, failed := 0, 0
+	for _, file := range files {
+		n, diags, err := m.Test(file)
+		if err != nil {
+			return fmt.Errorf("failed to test %s: %w", file, err)
+		}
+		checked += n
+		failed += len(diags)
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("%d problems found in %d expectations", failed, checked)
+	}
+	fmt.Printf("%d expectations passed\n", checked)
+	return nil
+}
+
+func stats(ctx context.Context, cmd *cli.Command) error {
+	root := expandPath(cmd.String("root"))
+	logger := consoleLogger()
+	mcfg := matcherConfig(cmd.Bool("gitignore"), logger)
+	mcfg.Stats = true
+	m, err := matcher.NewMatcherWithConfig(mcfg)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	p, err := poller.NewPoller(poller.Config{
+		Root: root,
+		Handler: func(path string, d fs.DirEntry) error {
+			res, err := m.Match(path, d)
+			if err != nil || !res.Ignored {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			m.Record(path, res, info)
+			if d.IsDir() {
+				return poller.ErrSkipDir
+			}
+			return nil
+		},
+		Logger: logging.Discard(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create scanner: %w", err)
 	}
+	if err := p.Scan(); err != nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+	
+	// Rules that ignored nothing are listed too, so they can be pruned
+	ruleStats := m.Stats()
+	seen := make(map[string]bool)
+	for _, s := range ruleStats {
+		seen[fmt.Sprintf("%s:%d", s.File, s.Line)] = true
+	}
+	files, err := m.FindRuleFiles(root)
+	if err != nil {
+		return fmt.Errorf("failed to find ignore files: %w", err)
+	}
+	for _, file := range files {
+		f, err := m.LoadIgnoreFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		for _, rule := range f.Rules {
+			if !rule.Negate && !seen[fmt.Sprintf("%s:%d", rule.File, rule.Line)] {
+				ruleStats = append(ruleStats, matcher.RuleStats{File: rule.File, Line: rule.Line, Pattern: rule.Pattern})
+			}
+		}
+	}
+	
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BYTES\tENTRIES\tMATCHES\t RULE")
+	for _, s := range ruleStats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t %s:%d: %s\n", report.FormatBytes(s.Bytes), s.Entries, s.Matches, s.File, s.Line, s.Pattern)
+	}
+	return w.Flush()
+}
+
+func writeReport(ctx context.Context, cmd *cli.Command) error {
+	format := cmd.String("format")
+	if !slices.Contains(report.Formats, format) {
+		return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(report.Formats, ", "))
+	}
+	
+	logger := consoleLogger()
+	m, err := newMatcher(cmd.Bool("gitignore"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	cfg := report.Config{
+		Root:    expandPath(cmd.String("root")),
+		Matcher: m,
+		Logger:  logger,
+	}
+	if path := cmd.String("audit-log"); path != "" {
+		cfg.AuditLog = expandPath(path)
+	}
+	r, err := report.Collect(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to collect report: %w", err)
+	}
+	
+	out := io.Writer(os.Stdout)
+	if path := cmd.String("output"); path != "" {
+		f, err := os.Create(expandPath(path))
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	return r.Write(out, format)
+}
+
+func suggestRules(ctx context.Context, cmd *cli.Command) error {
+	minSize, err := matcher.ParseSize(cmd.String("min-size"))
+	if err != nil {
+		return fmt.Errorf("invalid min-size: %w", err)
+	}
+	
+	root := expandPath(cmd.String("root"))
+	logger := consoleLogger()
+	m, err := newMatcher(cmd.Bool("gitignore"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	suggestions, err := suggest.Analyze(suggest.Config{
+		Root:    root,
+		Matcher: m,
+		MinSize: minSize,
+		Logger:  logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", root, err)
+	}
+	if len(suggestions) == 0 {
+		fmt.Println("No suggestions")
+		return nil
+	}
+	
+	if cmd.Bool("patch") {
+		patch, err := suggest.Patch(root, suggestions)
+		if err != nil {
+			return fmt.Errorf("failed to create patch: %w", err)
+		}
+		fmt.Print(patch)
+		return nil
+	}
+	
+	if cmd.Bool("interactive") {
+		var accepted []suggest.Suggestion
+		in := bufio.NewReader(os.Stdin)
+		for _, s := range suggestions {
+			fmt.Printf("%s\n%s\nAdd to %s? [y/N] ", strings.Join(s.Lines(), "\n"), strings.Join(s.Paths, "\n"), s.File)
+			answer, err := in.ReadString('\n')
+			if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "y" || answer == "yes" {
+				accepted = append(accepted, s)
+			}
+			if err != nil {
+				fmt.Println()
+				break
+			}
+		}
+		if err := appendSuggestions(accepted, logger); err != nil {
+			return fmt.Errorf("failed to append suggestions: %w", err)
+		}
+		fmt.Printf("Added %d of %d suggestions\n", len(accepted), len(suggestions))
+		return nil
+	}
+	
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tFILES\tPATTERN\tFILE\tREASONS")
+	for _, s := range suggestions {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", report.FormatBytes(s.Size), s.Files, s.Pattern, s.File, strings.Join(s.Reasons, ", "))
+	}
+	return tw.Flush()
+}
+
+// appendSuggestions adds suggestions to their ignore files, warning about
+// new files that take over from the rules of a file further up
+func appendSuggestions(suggestions []suggest.Suggestion, logger *slog.Logger) error {
+	warned := make(map[string]bool)
+	for _, s := range suggestions {
+		if warned[s.File] {
+			continue
+		}
+		warned[s.File] = true
+		if hidden := matcher.HiddenRules(s.File); hidden != "" {
+			logger.Warn("Creating an ignore file stops the rules of the one above from applying below it",
+				"file", s.File, "hidden", hidden)
+		}
+	}
+	return suggest.Append(suggestions)
+}
+
+func adopt(ctx context.Context, cmd *cli.Command) error {
+	root := expandPath(cmd.String("root"))
+	logger := consoleLogger()
+	m, err := newMatcher(cmd.Bool("gitignore"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
+	
+	var excluded []string
+	if path := cmd.String("exclude-list"); path != "" {
+		f, err := os.Open(expandPath(path))
+		if err != nil {
+			return fmt.Errorf("failed to open exclude list: %w", err)
+		}
+		excluded, err = suggest.ParseExcludeList(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read exclude list: %w", err)
+		}
+	}
+	
+	suggestions, err := suggest.Adopt(suggest.AdoptConfig{
+		Root:     root,
+		Matcher:  m,
+		Excluded: excluded,
+		Logger:   logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+	if len(suggestions) == 0 {
+		fmt.Println("Nothing is ignored without a rule")
+		return nil
+	}
+	
+	switch {
+	case cmd.Bool("patch"):
+		patch, err := suggest.Patch(root, suggestions)
+		if err != nil {
+			return fmt.Errorf("failed to create patch: %w", err)
+		}
+		fmt.Print(patch)
+	case cmd.Bool("write"):
+		if err := appendSuggestions(suggestions, logger); err != nil {
+			return fmt.Errorf("failed to append rules: %w", err)
+		}
+		fmt.Printf("Added %d rules\n", len(suggestions))
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATTERN\tPATHS\tFILE\tREASONS")
+		for _, s := range suggestions {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Pattern, len(s.Paths), s.File, strings.Join(s.Reasons, ", "))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+func revertMarks(ctx context.Context, cmd *cli.Command) error {
+	filter := revert.Filter{Rule: cmd.String("rule")}
+	if since := cmd.String("since"); since != "" {
+		t, err := revert.ParseSince(since, time.Now())
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+	if path := cmd.String("path"); path != "" {
+		filter.Prefix = expandPath(path)
+	}
+	
+	maxSize, err := matcher.ParseSize(cmd.String("audit-max-size"))
+	if err != nil {
+		return fmt.Errorf("invalid audit-max-size: %w", err)
+	}
+	
+	// Each mark is cleared where it was set, on the link itself under
+	// --symlinks mark
+	marker := func(noFollow bool) xattr.Marker {
+		mk := newMarker(cmd)
+		mk.NoFollow = noFollow
+		return mk
+	}
+	r, err := revert.NewReverter(revert.Config{
+		AuditLog:     expandPath(cmd.String("audit-log")),
+		AuditMaxSize: maxSize,
+		Filter:       filter,
+		IsIgnored: func(path string, noFollow bool) (bool, error) {
+			return marker(noFollow).IsIgnored(path)
+		},
+		RemoveIgnored: func(path string, noFollow bool) error {
+			return marker(noFollow).RemoveIgnored(path)
+		},
+		Logger: consoleLogger(),
+	})
+	if err != nil {
+		return err
+	}
+	plan, err := r.Plan()
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	
+	for _, rec := range plan.Revert {
+		fmt.Printf("revert %s (marked %s by %s)\n", rec.Path, rec.Time.Local().Format("2006-01-02 15:04:05"), rec.Rule)
+	}
+	for _, skip := range plan.Skipped {
+		fmt.Printf("skip   %s: %s\n", skip.Path, skip.Reason)
+	}
+	if len(plan.Revert) == 0 {
+		fmt.Printf("Nothing to revert (%d skipped)\n", len(plan.Skipped))
+		return nil
+	}
+	if cmd.Bool("dry-run") {
+		fmt.Printf("[DRY RUN] Would revert %d paths (%d skipped)\n", len(plan.Revert), len(plan.Skipped))
+		return nil
+	}
+	
+	if !cmd.Bool("yes") {
+		fmt.Println("Stop the daemon before reverting, or it will mark the paths again")
+		fmt.Printf("Clear the ignore attribute on %d paths? [y/N] ", len(plan.Revert))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+	
+	res, err := r.Apply(plan)
+	if err != nil {
+		return fmt.Errorf("failed to revert: %w", err)
+	}
+	for _, failure := range res.Failed {
+		fmt.Printf("failed %s: %s\n", failure.Path, failure.Reason)
+	}
+	fmt.Printf("Reverted %d paths, %d skipped, %d failed\n", res.Reverted, len(plan.Skipped), len(res.Failed))
+	fmt.Println("Fix or remove the rules first, or the daemon will mark the paths again")
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d paths could not be reverted", len(res.Failed))
+	}
+	return nil
+}
+
+func saveSnapshot(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("a snapshot file is required")
+	}
+	
+	s, err := snapshot.NewSnapshotter(snapshot.Config{
+		Root:   expandPath(cmd.String("root")),
+		Logger: consoleLogger(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create snapshotter: %w", err)
+	}
+	snap, err := s.Capture()
+	if err != nil {
+		return fmt.Errorf("failed to capture snapshot: %w", err)
+	}
+	
+	path := expandPath(cmd.Args().First())
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	if err := snap.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	fmt.Printf("Saved %d ignored paths under %s to %s\n", len(snap.Entries), snap.Root, path)
+	return nil
+}
+
+func restoreSnapshot(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("a snapshot file is required")
+	}
+	
+	f, err := os.Open(expandPath(cmd.Args().First()))
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	snap, err := snapshot.Read(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	
+	root := snap.Root
+	if cmd.String("root") != "" {
+		root = expandPath(cmd.String("root"))
+	}
+	mk := newMarker(cmd)
+	s, err := snapshot.NewSnapshotter(snapshot.Config{
+		Root:          root,
+		IsIgnored:     mk.IsIgnored,
+		SetIgnored:    mk.SetIgnored,
+		RemoveIgnored: mk.RemoveIgnored,
+		Logger:        consoleLogger(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create snapshotter: %w", err)
+	}
+	changes, err := s.Diff(snap)
+	if err != nil {
+		return fmt.Errorf("failed to compare snapshot: %w", err)
+	}
+	
+	exact := cmd.Bool("exact")
+	pending := 0
+	for _, c := range changes {
+		switch c.Kind {
+		case snapshot.Set:
+			fmt.Printf("+ %s\n", c.Path)
+			pending++
+		case snapshot.Moved:
+			fmt.Printf("+ %s (moved from %s)\n", c.Path, c.From)
+			pending++
+		case snapshot.Missing:
+			fmt.Printf("? %s (not found)\n", c.Path)
+		case snapshot.Extra:
+			if exact {
+				fmt.Printf("- %s\n", c.Path)
+				pending++
+			} else {
+				fmt.Printf("  %s (not in snapshot, kept)\n", c.Path)
+			}
+		}
+	}
+	if pending == 0 {
+		fmt.Println("Nothing to restore")
+		return nil
+	}
+	if cmd.Bool("dry-run") {
+		fmt.Printf("[DRY RUN] Would change %d paths\n", pending)
+		return nil
+	}
+	
+	if !cmd.Bool("yes") {
+		fmt.Printf("Apply %d changes? [y/N] ", pending)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+	
+	res := s.Restore(changes, exact)
+	for _, err := range res.Failed {
+		fmt.Printf("failed %v\n", err)
+	}
+	fmt.Printf("Set %d, cleared %d, failed %d\n", res.Set, res.Cleared, len(res.Failed))
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d paths could not be restored", len(res.Failed))
+	}
+	return nil
+}
+
+func status(ctx context.Context, cmd *cli.Command) error {
+	path := retryQueuePath(cmd)
+	if path == "" {
+		return fmt.Errorf("no retry queue configured")
+	}
+	entries, err := retry.Load(path)
+	if err != nil {
+		return err
+	}
+	
+	var pending, failed []retry.Entry
+	for _, e := range entries {
+		if e.Failed {
+			failed = append(failed, e)
+		} else {
+			pending = append(pending, e)
+		}
+	}
+	fmt.Printf("Retry queue %s: %d pending, %d failed\n", path, len(pending), len(failed))
+	if len(pending) > 0 {
+		fmt.Println("\nWaiting to be retried:")
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ATTEMPTS\tNEXT ATTEMPT\tERROR\tPATH")
+		for _, e := range pending {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.Attempts, e.NextAttempt.Local().Format(time.DateTime), e.LastError, e.Path)
+		}
+		tw.Flush()
+	}
+	if len(failed) > 0 {
+		fmt.Println("\nGiven up on:")
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ATTEMPTS\tFIRST FAILURE\tERROR\tPATH")
+		for _, e := range failed {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.Attempts, e.FirstFailure.Local().Format(time.DateTime), e.LastError, e.Path)
+		}
+		tw.Flush()
+	}
+	return nil
+}
+
+func serveLSP(ctx context.Context, cmd *cli.Command) error {
+	// stdout carries the protocol, so logs go to stderr
+	logger, _, err := logging.New(logging.Config{Output: os.Stderr})
+	if err != nil {
+		return err
+	}
+	m, err := newMatcher(cmd.Bool("gitignore"), logger)
+	if err != nil {
+		return fmt.Errorf("failed to create matcher: %w", err)
+	}
 	
-	cfg.logger.Printf("Scanning %s", cfg.root)
-	return p.Scan()
+	server, err := lsp.NewServer(lsp.Config{
+		In:      os.Stdin,
+		Out:     os.Stdout,
+		Matcher: m,
+		Logger:  logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create language server: %w", err)
+	}
+	return server.Run()
 }
 
 func install(ctx context.Context, cmd *cli.Command) error {
@@ -227,48 +1266,151 @@ func uninstall(ctx context.Context, cmd *cli.Command) error {
 
 // config holds common configuration extracted from CLI flags
 type config struct {
-	root   string
-	dryRun bool
-	logger *log.Logger
+	root      string
+	dryRun    bool
+	gitIgnore bool
+	marker    xattr.Marker
+	symlinks  symlink.Resolver
+	workers   int
+	logger    *slog.Logger
+	closeLog  io.Closer
 }
 
 // getConfig extracts common configuration from CLI command
-func getConfig(cmd *cli.Command) config {
+func getConfig(cmd *cli.Command) (config, error) {
+	policy, err := symlink.ParsePolicy(cmd.String("symlinks"))
+	if err != nil {
+		return config{}, err
+	}
+	logger, closeLog, err := setupLogger(cmd)
+	if err != nil {
+		return config{}, err
+	}
+	root := expandPath(cmd.String("root"))
+	marker := newMarker(cmd)
+	marker.NoFollow = policy == symlink.Mark
 	return config{
-		root:   expandPath(cmd.String("root")),
-		dryRun: cmd.Bool("dry-run"),
-		logger: setupLogger(cmd.Bool("verbose")),
+		root:      root,
+		dryRun:    cmd.Bool("dry-run"),
+		gitIgnore: cmd.Bool("gitignore"),
+		marker:    marker,
+		symlinks:  symlink.Resolver{Policy: policy, Root: root},
+		workers:   scanWorkers(cmd),
+		logger:    logger,
+		closeLog:  closeLog,
+	}, nil
+}
+
+// scanWorkers returns the number of scan workers. Low-resource mode also
+// limits the process to one thread.
+func scanWorkers(cmd *cli.Command) int {
+	if cmd.Bool("low-resource") {
+		runtime.GOMAXPROCS(1)
+		return 1
 	}
+	return max(cmd.Int("workers"), 1)
 }
 
-// createHandler creates a synchronous handler without worker pool
-func createHandler(m *matcher.Matcher, cache *state.Cache, dryRun bool, logger *log.Logger) func(string, fs.FileInfo) error {
-	return func(path string, info fs.FileInfo) error {
-		// Check cache
-		if cache.Has(path, info) {
+// newMarker creates the attribute writer configured by the flags
+func newMarker(cmd *cli.Command) xattr.Marker {
+	return xattr.Marker{MakeWritable: cmd.Bool("make-writable")}
+}
+
+// newMatcher creates the pattern matcher shared by all commands
+func newMatcher(gitIgnore bool, logger *slog.Logger) (*matcher.Matcher, error) {
+	return matcher.NewMatcherWithConfig(matcherConfig(gitIgnore, logger))
+}
+
+// matcherConfig is the configuration of the matcher shared by all commands
+func matcherConfig(gitIgnore bool, logger *slog.Logger) matcher.Config {
+	if logger != nil {
+		logger = logging.For(logger, logging.Matcher)
+	}
+	return matcher.Config{
+		CacheSize: 32,
+		GitIgnore: gitIgnore,
+		Logger:    logger,
+	}
+}
+
+// openAuditLog opens the audit log given by the flags, or returns nil
+// when none is configured
+func openAuditLog(cmd *cli.Command, logger *slog.Logger) (*audit.Log, error) {
+	path := cmd.String("audit-log")
+	if path == "" {
+		return nil, nil
+	}
+	maxSize, err := matcher.ParseSize(cmd.String("audit-max-size"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit-max-size: %w", err)
+	}
+	return audit.Open(audit.Config{
+		Path:    expandPath(path),
+		MaxSize: maxSize,
+		Logger:  logger,
+	})
+}
+
+// handlerConfig holds what the handler needs. auditLog, metrics and
+// retries are optional.
+type handlerConfig struct {
+	matcher  *matcher.Matcher
+	cache    *state.Cache
+	auditLog *audit.Log
+	metrics  *daemonMetrics
+	retries  *retry.Queue
+	marker   xattr.Marker
+	symlinks symlink.Resolver
+	dryRun   bool
+	logger   *slog.Logger
+}
+
+// createHandler creates a synchronous handler without worker pool. The
+// source tells the audit log what noticed the path, and seen, when set, is
+// the time of the filesystem event that led to it. Transient attribute
+// write failures, and read-only paths that need a directory-level ignore,
+// go to retries, if set, and the returned retry function writes the
+// attribute of a queued path again.
+func createHandler(hc handlerConfig) (handler func(string, fs.DirEntry, string, time.Time) error, retryPath func(string) error) {
+	matchLog := logging.For(hc.logger, logging.Matcher)
+	xattrLog := logging.For(hc.logger, logging.Xattr)
+	
+	// mark sets the attribute if the path should be ignored, returning
+	// the error of writing it. Only paths to ignore are stat'ed, unless
+	// a rule's predicates need the metadata.
+	mark := func(path string, d fs.DirEntry, source string, seen time.Time) error {
+		// Check if should ignore
+		res, err := hc.matcher.Match(path, d)
+		if err != nil {
+			matchLog.Warn("Matcher error", "path", path, "error", err)
+			return nil // Continue processing other files
+		}
+		
+		if !res.Ignored {
 			return nil
 		}
 		
-		// Check if should ignore
-		shouldIgnore, err := m.ShouldIgnore(path)
+		info, err := d.Info()
 		if err != nil {
-			logger.Printf("Matcher error for %s: %v", path, err)
-			return nil // Continue processing other files
+			hc.logger.Warn("Failed to get info", "path", path, "error", err)
+			return nil
 		}
 		
-		if !shouldIgnore {
+		// Check cache
+		if hc.cache.Has(path, info) {
 			return nil
 		}
 		
 		// Check if already ignored
-		ignored, err := xattr.IsIgnored(path)
+		ignored, err := hc.marker.IsIgnored(path)
 		if err != nil {
-			logger.Printf("Failed to check xattr for %s: %v", path, err)
+			xattrLog.Warn("Failed to check xattr", "path", path, "error", err)
+			hc.metrics.xattrError(err)
 			return nil // Continue processing other files
 		}
 		
 		if ignored {
-			cache.Add(path, info)
+			hc.cache.Add(path, info)
 			// If it's an ignored directory, skip its contents
 			if info.IsDir() {
 				return poller.ErrSkipDir
@@ -277,17 +1419,39 @@ func createHandler(m *matcher.Matcher, cache *state.Cache, dryRun bool, logger *
 		}
 		
 		// Set ignore attribute
-		if dryRun {
-			logger.Printf("[DRY RUN] Would set ignore attribute on: %s", path)
+		if hc.dryRun {
+			xattrLog.Info("Would set ignore attribute", "path", path, "dry_run", true)
 		} else {
-			if err := xattr.SetIgnored(path); err != nil {
-				logger.Printf("Failed to set xattr for %s: %v", path, err)
-				return nil // Continue processing other files
+			if err := hc.marker.SetIgnored(path); err != nil {
+				if errors.Is(err, xattr.ErrNeedsDirectoryIgnore) {
+					xattrLog.Warn("Read-only path rejects the ignore attribute, needs directory-level ignore",
+						"path", path, "dir", filepath.Dir(path), "error", err)
+				} else {
+					xattrLog.Warn("Failed to set xattr", "path", path, "error", err)
+				}
+				hc.metrics.xattrError(err)
+				return err
 			}
-			logger.Printf("Set ignore attribute on: %s", path)
+			xattrLog.Info("Set ignore attribute", "path", path)
 		}
+		hc.metrics.observeMark(seen)
 		
-		cache.Add(path, info)
+		if hc.auditLog != nil {
+			err := hc.auditLog.Write(audit.Record{
+				Path:     path,
+				Inode:    state.Inode(info),
+				Action:   audit.ActionSet,
+				Rule:     res.Describe(),
+				Source:   source,
+				DryRun:   hc.dryRun,
+				NoFollow: hc.marker.NoFollow,
+			})
+			if err != nil {
+				hc.logger.Error("Failed to write audit log", "error", err)
+			}
+		}
+		
+		hc.cache.Add(path, info)
 		
 		// If we just set ignore on a directory, skip its contents
 		if info.IsDir() {
@@ -295,13 +1459,86 @@ func createHandler(m *matcher.Matcher, cache *state.Cache, dryRun bool, logger *
 		}
 		return nil
 	}
+	
+	handler = func(path string, d fs.DirEntry, source string, seen time.Time) error {
+		err := mark(path, d, source, seen)
+		if err == nil || errors.Is(err, poller.ErrSkipDir) {
+			if hc.retries != nil {
+				hc.retries.Remove(path)
+			}
+			return err
+		}
+		switch {
+		case hc.retries == nil:
+		case retry.Transient(err):
+			hc.retries.Add(path, err)
+			xattrLog.Info("Queued for retry", "path", path)
+		case errors.Is(err, xattr.ErrNeedsDirectoryIgnore):
+			// Recorded as failed so that status reports it
+			hc.retries.Add(path, err)
+		}
+		return nil // Continue processing other files
+	}
+	
+	retryPath = func(path string) error {
+		info, err := hc.symlinks.Stat(path)
+		if errors.Is(err, symlink.ErrSkipped) || errors.Is(err, symlink.ErrEscapesRoot) {
+			return nil // Now a link the policy leaves alone
+		}
+		if err != nil {
+			return err
+		}
+		// The path may be cached from before the failure
+		hc.cache.Remove(path)
+		if err := mark(path, fs.FileInfoToDirEntry(info), audit.SourceRetry, time.Time{}); err != nil && !errors.Is(err, poller.ErrSkipDir) {
+			return err
+		}
+		return nil
+	}
+	return handler, retryPath
+}
+
+// setupLogger creates the logger configured by the logging flags.
+// --verbose is short for --log-level debug.
+func setupLogger(cmd *cli.Command) (*slog.Logger, io.Closer, error) {
+	level, err := logging.ParseLevel(cmd.String("log-level"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if cmd.Bool("verbose") {
+		level = slog.LevelDebug
+	}
+	return logging.New(logging.Config{
+		Level:    level,
+		Format:   cmd.String("log-format"),
+		Journald: cmd.Bool("log-journald"),
+		Syslog:   cmd.String("log-syslog"),
+	})
 }
 
-func setupLogger(verbose bool) *log.Logger {
-	if verbose {
-		return log.New(os.Stdout, "", log.LstdFlags)
+// consoleLogger creates the logger of one-off commands, which have no
+// logging flags
+func consoleLogger() *slog.Logger {
+	logger, _, _ := logging.New(logging.Config{})
+	return logger
+}
+
+// retryQueuePath returns the retry queue file given by the flags, or empty
+// when the queue is disabled
+func retryQueuePath(cmd *cli.Command) string {
+	if cmd.String("retry-queue") == "" {
+		return ""
+	}
+	return expandPath(cmd.String("retry-queue"))
+}
+
+// defaultRetryQueue is the retry queue file in the user cache directory
+func defaultRetryQueue() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
 	}
-	return log.New(os.Stdout, "", 0)
+	return filepath.Join(dir, "dbxignore", "retry.json")
 }
 
 func expandPath(path string) string {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/metrics"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/poller"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/retry"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/watcher"
+	"golang.org/x/sys/unix"
+)
+
+// daemonMetrics are the metrics served with --metrics-addr. A nil
+// *daemonMetrics records nothing, so callers need not check.
+type daemonMetrics struct {
+	registry *metrics.Registry
+
+	scans        *metrics.Counter
+	scanDuration *metrics.Histogram
+	scanFiles    *metrics.Gauge
+	scanDirs     *metrics.Gauge
+	scanSkipped  *metrics.Gauge
+	scanListed   *metrics.Gauge
+	scanLstats   *metrics.Gauge
+	marks        *metrics.Counter
+	markLatency  *metrics.Histogram
+	xattrErrors  *metrics.CounterVec
+}
+
+func newDaemonMetrics(m *matcher.Matcher, cache *state.Cache) *daemonMetrics {
+	r := metrics.NewRegistry()
+	dm := &daemonMetrics{
+		registry:     r,
+		scans:        r.NewCounter("dbxignore_scans_total", "Completed poller scans."),
+		scanDuration: r.NewHistogram("dbxignore_scan_duration_seconds", "Duration of poller scans.", metrics.DefBuckets),
+		scanFiles:    r.NewGauge("dbxignore_scan_files", "Files visited by the last scan."),
+		scanDirs:     r.NewGauge("dbxignore_scan_dirs", "Directories visited by the last scan."),
+		scanSkipped:  r.NewGauge("dbxignore_scan_skipped_dirs", "Directories skipped by the last scan."),
+		scanListed:   r.NewGauge("dbxignore_scan_listed_dirs", "Directories the last scan read rather than reused from the one before."),
+		scanLstats:   r.NewGauge("dbxignore_scan_lstats", "Entries whose metadata the last scan read."),
+		marks:        r.NewCounter("dbxignore_marks_total", "Paths marked as ignored."),
+		markLatency: r.NewHistogram("dbxignore_event_to_mark_seconds",
+			"Time from the last filesystem event on a path to marking it.", metrics.DefBuckets),
+		xattrErrors: r.NewCounterVec("dbxignore_xattr_errors_total", "Failed extended attribute operations by errno.", "errno"),
+	}
+	r.NewCounterFunc("dbxignore_matcher_cache_hits_total", "Rule file lookups served from the matcher cache.", func() float64 {
+		hits, _ := m.CacheStats()
+		return float64(hits)
+	})
+	r.NewCounterFunc("dbxignore_matcher_cache_misses_total", "Rule file lookups that loaded the file.", func() float64 {
+		_, misses := m.CacheStats()
+		return float64(misses)
+	})
+	r.NewGaugeFunc("dbxignore_state_cache_entries", "Entries in the processed-path cache.", func() float64 {
+		return float64(cache.Size())
+	})
+	return dm
+}
+
+// addWatcher exposes the watcher's watch count and queue depth
+func (dm *daemonMetrics) addWatcher(w *watcher.Watcher) {
+	if dm == nil {
+		return
+	}
+	dm.registry.NewGaugeFunc("dbxignore_watched_dirs", "Directories watched for events.", func() float64 {
+		return float64(w.WatchCount())
+	})
+	dm.registry.NewGaugeFunc("dbxignore_watcher_pending_events", "Events waiting for their debounce period.", func() float64 {
+		return float64(w.Pending())
+	})
+}
+
+// addRetryQueue exposes the size of the retry queue
+func (dm *daemonMetrics) addRetryQueue(q *retry.Queue) {
+	if dm == nil {
+		return
+	}
+	dm.registry.NewGaugeFunc("dbxignore_retry_pending", "Paths waiting to be retried.", func() float64 {
+		pending, _ := q.Len()
+		return float64(pending)
+	})
+	dm.registry.NewGaugeFunc("dbxignore_retry_failed", "Paths given up on after repeated failures.", func() float64 {
+		_, failed := q.Len()
+		return float64(failed)
+	})
+}
+
+func (dm *daemonMetrics) observeScan(s poller.ScanStats) {
+	if dm == nil {
+		return
+	}
+	dm.scans.Inc()
+	dm.scanDuration.Observe(s.Duration.Seconds())
+	dm.scanFiles.Set(float64(s.Files))
+	dm.scanDirs.Set(float64(s.Dirs))
+	dm.scanSkipped.Set(float64(s.Skipped))
+	dm.scanListed.Set(float64(s.Listed))
+	dm.scanLstats.Set(float64(s.Lstats))
+}
+
+// observeMark counts a mark and, for marks caused by an event, its latency
+func (dm *daemonMetrics) observeMark(seen time.Time) {
+	if dm == nil {
+		return
+	}
+	dm.marks.Inc()
+	if !seen.IsZero() {
+		dm.markLatency.Observe(time.Since(seen).Seconds())
+	}
+}
+
+func (dm *daemonMetrics) xattrError(err error) {
+	if dm == nil {
+		return
+	}
+	dm.xattrErrors.Inc(errnoName(err))
+}
+
+// errnoName names the errno behind err, such as EACCES
+func errnoName(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if name := unix.ErrnoName(errno); name != "" {
+			return name
+		}
+		return errno.Error()
+	}
+	return "other"
+}
@@ -3,9 +3,8 @@ module github.com/gghcode/dropbox-ignore-daemon
 go 1.24.5
 
 require (
-	github.com/fsnotify/fsnotify v1.9.0 // indirect
-	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06 // indirect
-	github.com/urfave/cli/v3 v3.3.8 // indirect
-	golang.org/x/sys v0.34.0 // indirect
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/hashicorp/golang-lru/v2 v2.0.7
+	github.com/urfave/cli/v3 v3.3.8
+	golang.org/x/sys v0.34.0
 )
@@ -0,0 +1,256 @@
+// Package audit records every ignore attribute change in an append-only
+// JSON Lines file, rotated by size.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default size at which the log is rotated
+	defaultMaxSize = 10 << 20
+	// Default number of rotated files kept
+	defaultMaxBackups = 3
+)
+
+// Action is an attribute change
+type Action string
+
+const (
+	ActionSet    Action = "set"
+	ActionRemove Action = "remove"
+)
+
+// Sources of a change
+const (
+	SourceWatcher = "watcher"
+	SourcePoller  = "poller"
+	SourceScan    = "scan"
+	SourceRevert  = "revert"
+	SourceRetry   = "retry"
+)
+
+// Record is one line of the audit log
+type Record struct {
+	Time time.Time `json:"time"`
+	Path string    `json:"path"`
+	// Inode is that of what carries the attribute: the target of a
+	// followed link, the link itself with NoFollow
+	Inode  uint64 `json:"inode,omitempty"`
+	Action Action `json:"action"`
+	// Rule is the rule that triggered the change, if any
+	Rule string `json:"rule,omitempty"`
+	// Source is what noticed the path: the watcher, the poller or a command
+	Source string `json:"source"`
+	DryRun bool   `json:"dry_run"`
+	// NoFollow is set when the attribute is that of a symbolic link
+	// itself rather than of its target
+	NoFollow bool `json:"no_follow,omitempty"`
+}
+
+// Stat returns the metadata of what carries the record's attribute, to
+// compare with its Inode: that of the link itself with NoFollow, and
+// otherwise that of the path with links followed
+func (r Record) Stat() (os.FileInfo, error) {
+	if r.NoFollow {
+		return os.Lstat(r.Path)
+	}
+	return os.Stat(r.Path)
+}
+
+// Config holds audit log configuration
+type Config struct {
+	Path string
+	// MaxSize is the size in bytes at which the log is rotated
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept as Path.1, Path.2, ...
+	MaxBackups int
+	Logger     *slog.Logger
+}
+
+// Log is an audit log, safe for concurrent use
+type Log struct {
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	config Config
+}
+
+// Open opens the log for appending, creating it and its directory as
+// needed
+func Open(cfg Config) (*Log, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit log path is required")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxSize
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = defaultMaxBackups
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{config: cfg}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends a record, rotating the log first if it would grow past
+// MaxSize. A zero Time is set to now.
+func (l *Log) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			l.config.Logger.Warn("Failed to rotate audit log", "path", l.config.Path, "error", err)
+			// Keep appending to the current file
+			if l.file == nil {
+				if err := l.open(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts Path.N to Path.N+1, dropping the oldest, and starts a new
+// file at Path
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	for i := l.config.MaxBackups - 1; i >= 1; i-- {
+		old := fmt.Sprintf("%s.%d", l.config.Path, i)
+		if _, err := os.Stat(old); err == nil {
+			if err := os.Rename(old, fmt.Sprintf("%s.%d", l.config.Path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(l.config.Path, l.config.Path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Read decodes the records of a log file, oldest first
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// ReadAll decodes a log and its rotated files, oldest first
+func ReadAll(path string) ([]Record, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	// Higher suffixes are older
+	backups := make(map[int]string)
+	var suffixes []int
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || n < 1 {
+			continue
+		}
+		backups[n] = match
+		suffixes = append(suffixes, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(suffixes)))
+	files := make([]string, 0, len(suffixes)+1)
+	for _, n := range suffixes {
+		files = append(files, backups[n])
+	}
+	files = append(files, path)
+
+	var records []Record
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		recs, err := Read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
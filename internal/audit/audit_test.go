@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "audit.jsonl")
+	l, err := Open(Config{Path: path})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Write(Record{Path: fmt.Sprintf("/d/%d", i), Inode: uint64(i + 1), Action: ActionSet, Rule: ".dropboxignore:1: *.log", Source: SourcePoller})
+			if err != nil {
+				t.Errorf("Write failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := l.Write(Record{Path: "/late"}); err == nil {
+		t.Error("Expected an error writing to a closed log")
+	}
+
+	// Reopening appends
+	l, err = Open(Config{Path: path})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	l.Write(Record{Path: "/d/last", Action: ActionRemove, Source: SourceScan, DryRun: true})
+	l.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer f.Close()
+	records, err := Read(f)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 21 {
+		t.Fatalf("Expected 21 records, got %d", len(records))
+	}
+	last := records[20]
+	if last.Path != "/d/last" || last.Action != ActionRemove || last.Source != SourceScan || !last.DryRun || last.Time.IsZero() {
+		t.Errorf("Unexpected record %+v", last)
+	}
+	if records[0].Rule != ".dropboxignore:1: *.log" || records[0].Inode == 0 {
+		t.Errorf("Unexpected record %+v", records[0])
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(Config{Path: path, MaxSize: 200, MaxBackups: 2, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer l.Close()
+
+	// Each record is about 100 bytes, so every other write rotates
+	for i := range 10 {
+		if err := l.Write(Record{Path: fmt.Sprintf("/some/longer/path/%d", i), Action: ActionSet, Source: SourceWatcher}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("Expected %s to be at most 200 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups")
+	}
+
+	// The newest records are in the current file
+	f, _ := os.Open(path)
+	defer f.Close()
+	records, err := Read(f)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) == 0 || records[len(records)-1].Path != "/some/longer/path/9" {
+		t.Errorf("Unexpected records %+v", records)
+	}
+}
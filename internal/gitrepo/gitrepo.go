@@ -0,0 +1,213 @@
+// Package gitrepo reads the parts of a git repository needed to evaluate
+// ignore rules: work tree discovery, core.excludesFile and the index of
+// tracked files. Everything is parsed natively without running git.
+package gitrepo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repo is a git work tree together with its git directories
+type Repo struct {
+	// Root is the top-level directory of the work tree
+	Root string
+	// GitDir holds per-worktree state such as the index
+	GitDir string
+	// CommonDir holds state shared between worktrees such as config and info/exclude
+	CommonDir string
+}
+
+// Find returns the work tree containing dir, walking up towards the
+// filesystem root. It reports false when dir is not inside a work tree.
+func Find(dir string) (*Repo, bool) {
+	for {
+		if repo, ok := open(dir); ok {
+			return repo, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, false
+		}
+		dir = parent
+	}
+}
+
+// open checks whether root is the top of a work tree
+func open(root string) (*Repo, bool) {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return nil, false
+	}
+
+	gitDir := dotGit
+	if !info.IsDir() {
+		// Worktrees and submodules use a "gitdir: <path>" file
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return nil, false
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+		if !ok {
+			return nil, false
+		}
+		gitDir = resolve(root, strings.TrimSpace(target))
+	}
+
+	commonDir := gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = resolve(gitDir, strings.TrimSpace(string(data)))
+	}
+
+	return &Repo{Root: root, GitDir: gitDir, CommonDir: commonDir}, true
+}
+
+// resolve makes p absolute relative to base
+func resolve(base, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(base, p)
+}
+
+// IndexFile returns the path of the repository index
+func (r *Repo) IndexFile() string {
+	return filepath.Join(r.GitDir, "index")
+}
+
+// InfoExcludeFile returns the path of the repository's info/exclude file
+func (r *Repo) InfoExcludeFile() string {
+	return filepath.Join(r.CommonDir, "info", "exclude")
+}
+
+// ExcludesFile returns the file named by core.excludesFile, falling back to
+// git's default of $XDG_CONFIG_HOME/git/ignore. Repository configuration
+// takes precedence over global and system configuration.
+func (r *Repo) ExcludesFile() string {
+	configs := []string{filepath.Join(r.CommonDir, "config")}
+	configs = append(configs, globalConfigFiles()...)
+
+	for _, path := range configs {
+		if value, ok := readConfigValue(path, "core", "excludesfile"); ok {
+			return expandHome(value)
+		}
+	}
+
+	if dir := xdgConfigHome(); dir != "" {
+		return filepath.Join(dir, "git", "ignore")
+	}
+	return ""
+}
+
+// globalConfigFiles lists global and system config files, highest precedence first
+func globalConfigFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+	if dir := xdgConfigHome(); dir != "" {
+		files = append(files, filepath.Join(dir, "git", "config"))
+	}
+	return append(files, "/etc/gitconfig")
+}
+
+// xdgConfigHome returns $XDG_CONFIG_HOME or its default
+func xdgConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config")
+	}
+	return ""
+}
+
+// expandHome expands a leading ~/ the way git does for path values
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// readConfigValue returns the last value of section.key in a git config
+// file. Section and key names are matched case-insensitively; subsections
+// and include directives are not supported.
+func readConfigValue(path, section, key string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	var (
+		current string
+		value   string
+		found   bool
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			current = strings.ToLower(strings.TrimSpace(line[1:end]))
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+		if current != section {
+			continue
+		}
+
+		name, val, ok := strings.Cut(line, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), key) {
+			continue
+		}
+		value, found = parseConfigValue(val), true
+	}
+
+	return value, found
+}
+
+// parseConfigValue strips quotes, escapes and trailing comments from a value
+func parseConfigValue(raw string) string {
+	var b strings.Builder
+	quoted := false
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(raw):
+			i++
+			switch raw[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(raw[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,175 @@
+package gitrepo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// initRepo creates a git repository with the given tracked files,
+// skipping the test if git is not installed
+func initRepo(t *testing.T, files ...string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	runGit(t, root, "init", "-q")
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", file, err)
+		}
+	}
+	runGit(t, root, append([]string{"add", "--"}, files...)...)
+	return root
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := initRepo(t, "README.md")
+	sub := filepath.Join(root, "a", "b")
+	os.MkdirAll(sub, 0755)
+
+	repo, ok := Find(sub)
+	if !ok {
+		t.Fatal("Expected to find repository")
+	}
+	if repo.Root != root {
+		t.Errorf("Expected root %s, got %s", root, repo.Root)
+	}
+	if repo.GitDir != filepath.Join(root, ".git") {
+		t.Errorf("Unexpected git dir %s", repo.GitDir)
+	}
+
+	if _, ok := Find(t.TempDir()); ok {
+		t.Error("Expected no repository outside a work tree")
+	}
+}
+
+func TestFindGitFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitDir := filepath.Join(tmpDir, "modules", "sub")
+	os.MkdirAll(gitDir, 0755)
+	workTree := filepath.Join(tmpDir, "sub")
+	os.MkdirAll(workTree, 0755)
+	if err := os.WriteFile(filepath.Join(workTree, ".git"), []byte("gitdir: ../modules/sub\n"), 0644); err != nil {
+		t.Fatalf("Failed to create .git file: %v", err)
+	}
+
+	repo, ok := Find(workTree)
+	if !ok {
+		t.Fatal("Expected to find repository")
+	}
+	if repo.GitDir != gitDir || repo.CommonDir != gitDir {
+		t.Errorf("Unexpected git dirs: %+v", repo)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	for _, version := range []string{"2", "3", "4"} {
+		t.Run("v"+version, func(t *testing.T) {
+			root := initRepo(t, "README.md", "src/main.go", "src/pkg/util.go", "docs/guide/intro.md")
+			runGit(t, root, "update-index", "--index-version", version)
+
+			repo, _ := Find(root)
+			idx, err := repo.ReadIndex()
+			if err != nil {
+				t.Fatalf("ReadIndex failed: %v", err)
+			}
+			if idx.Len() != 4 {
+				t.Errorf("Expected 4 entries, got %d", idx.Len())
+			}
+
+			tests := []struct {
+				path    string
+				tracked bool
+			}{
+				{"README.md", true},
+				{"src", true},
+				{"src/pkg", true},
+				{"src/pkg/util.go", true},
+				{"docs/guide", true},
+				{"src/other.go", false},
+				{"build", false},
+				{"READ", false},
+			}
+			for _, tt := range tests {
+				if got := idx.Tracked(tt.path); got != tt.tracked {
+					t.Errorf("Tracked(%s) = %v, expected %v", tt.path, got, tt.tracked)
+				}
+			}
+		})
+	}
+}
+
+func TestReadIndexMissing(t *testing.T) {
+	root := initRepo(t)
+	os.Remove(filepath.Join(root, ".git", "index"))
+
+	repo, _ := Find(root)
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	if idx.Len() != 0 {
+		t.Errorf("Expected empty index, got %d entries", idx.Len())
+	}
+}
+
+func TestParseIndexInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("DIRC"),
+		[]byte("XXXX\x00\x00\x00\x02\x00\x00\x00\x00"),
+		[]byte("DIRC\x00\x00\x00\x09\x00\x00\x00\x00"),
+		[]byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x01"),
+	} {
+		if _, err := ParseIndex(data, 20); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestExcludesFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+
+	gitDir := filepath.Join(t.TempDir(), ".git")
+	os.MkdirAll(gitDir, 0755)
+	repo := &Repo{Root: filepath.Dir(gitDir), GitDir: gitDir, CommonDir: gitDir}
+
+	// Default location
+	if got := repo.ExcludesFile(); got != filepath.Join(home, "xdg", "git", "ignore") {
+		t.Errorf("Unexpected default excludes file: %s", got)
+	}
+
+	// Global config with ~ expansion
+	global := "[user]\n\tname = test\n[Core]\n\texcludesFile = ~/global-ignore ; comment\n"
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte(global), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if got := repo.ExcludesFile(); got != filepath.Join(home, "global-ignore") {
+		t.Errorf("Unexpected global excludes file: %s", got)
+	}
+
+	// Repository config wins
+	local := "[core]\n\tbare = false\n\texcludesfile = \"/srv/repo ignore\"\n"
+	if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(local), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if got := repo.ExcludesFile(); got != "/srv/repo ignore" {
+		t.Errorf("Unexpected repository excludes file: %s", got)
+	}
+}
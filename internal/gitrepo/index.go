@@ -0,0 +1,167 @@
+package gitrepo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// Flag bit marking an entry that carries a second flags word (v3+)
+	indexExtendedFlag = 0x4000
+	// Fixed-size stat data preceding the object name in every entry
+	indexStatSize = 40
+)
+
+// ErrBadIndex is returned when the index file cannot be parsed
+var ErrBadIndex = errors.New("malformed git index")
+
+// Index is the set of paths tracked in a repository
+type Index struct {
+	files map[string]struct{}
+	dirs  map[string]struct{}
+}
+
+// ReadIndex parses the repository index. A missing index, as in a freshly
+// initialised repository, yields an empty Index.
+func (r *Repo) ReadIndex() (*Index, error) {
+	data, err := os.ReadFile(r.IndexFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return newIndex(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := 20
+	if format, ok := readConfigValue(filepath.Join(r.CommonDir, "config"), "extensions", "objectformat"); ok && format == "sha256" {
+		hashSize = 32
+	}
+	return ParseIndex(data, hashSize)
+}
+
+// ParseIndex decodes index versions 2, 3 and 4. Only entry paths are kept;
+// extensions and the trailing checksum are ignored.
+func ParseIndex(data []byte, hashSize int) (*Index, error) {
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, ErrBadIndex
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadIndex, version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	idx := newIndex()
+	pos := 12
+	var prev []byte
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		flagsPos := pos + indexStatSize + hashSize
+		if flagsPos+2 > len(data) {
+			return nil, ErrBadIndex
+		}
+		flags := binary.BigEndian.Uint16(data[flagsPos:])
+		pos = flagsPos + 2
+		if version >= 3 && flags&indexExtendedFlag != 0 {
+			pos += 2
+		}
+		if pos > len(data) {
+			return nil, ErrBadIndex
+		}
+
+		var name []byte
+		if version == 4 {
+			// Path is stored as "strip N bytes from the previous path" plus a suffix
+			strip, n := readOffset(data[pos:])
+			if n == 0 || strip > len(prev) {
+				return nil, ErrBadIndex
+			}
+			pos += n
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, ErrBadIndex
+			}
+			name = append(append([]byte{}, prev[:len(prev)-strip]...), data[pos:pos+end]...)
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, ErrBadIndex
+			}
+			name = data[pos : pos+end]
+			// Entries are NUL-padded to a multiple of eight bytes
+			pos = start + (pos+end-start+8)&^7
+		}
+
+		prev = name
+		idx.add(string(name))
+	}
+
+	return idx, nil
+}
+
+// readOffset decodes git's variable-length offset encoding
+func readOffset(buf []byte) (int, int) {
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	c := buf[0]
+	val := int(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(buf) {
+			return 0, 0
+		}
+		c = buf[n]
+		n++
+		val = ((val + 1) << 7) | int(c&0x7f)
+	}
+	return val, n
+}
+
+func newIndex() *Index {
+	return &Index{
+		files: make(map[string]struct{}),
+		dirs:  make(map[string]struct{}),
+	}
+}
+
+// add records a tracked path and all of its parent directories.
+// Sparse directory entries end in a slash and are recorded as directories.
+func (idx *Index) add(name string) {
+	if name == "" {
+		return
+	}
+	if name[len(name)-1] == '/' {
+		name = name[:len(name)-1]
+		idx.dirs[name] = struct{}{}
+	} else {
+		idx.files[name] = struct{}{}
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := idx.dirs[dir]; ok {
+			break
+		}
+		idx.dirs[dir] = struct{}{}
+	}
+}
+
+// Len returns the number of tracked entries
+func (idx *Index) Len() int {
+	return len(idx.files)
+}
+
+// Tracked reports whether a slash-separated path relative to the work tree
+// is a tracked file or a directory containing tracked files
+func (idx *Index) Tracked(rel string) bool {
+	if _, ok := idx.files[rel]; ok {
+		return true
+	}
+	_, ok := idx.dirs[rel]
+	return ok
+}
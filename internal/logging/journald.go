@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// journaldSocket is where journald accepts native protocol datagrams
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldConn is shared by a handler and those derived from it
+type journaldConn struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// journaldHandler sends records to journald using its native protocol, so
+// that attributes become fields that journalctl can filter on
+type journaldHandler struct {
+	conn  *journaldConn
+	tag   string
+	level slog.Leveler
+	// prefix is the field name prefix from groups
+	prefix string
+	// fields are attributes added with WithAttrs, already encoded
+	fields []byte
+}
+
+func newJournaldHandler(socket, tag string, level slog.Leveler) (*journaldHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldHandler{conn: &journaldConn{conn: conn}, tag: tag, level: level}, nil
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", r.Message)
+	writeField(&buf, "PRIORITY", strconv.Itoa(syslogPriority(r.Level)))
+	writeField(&buf, "SYSLOG_IDENTIFIER", h.tag)
+	buf.Write(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&buf, h.prefix, a)
+		return true
+	})
+
+	h.conn.mu.Lock()
+	defer h.conn.mu.Unlock()
+	_, err := h.conn.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var buf bytes.Buffer
+	buf.Write(h.fields)
+	for _, a := range attrs {
+		appendAttr(&buf, h.prefix, a)
+	}
+	clone := *h
+	clone.fields = buf.Bytes()
+	return &clone
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "_"
+	return &clone
+}
+
+func (h *journaldHandler) Close() error {
+	return h.conn.conn.Close()
+}
+
+// appendAttr encodes an attribute as a field, flattening groups
+func appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, prefix, ga)
+		}
+		return
+	}
+	writeField(buf, fieldName(prefix+a.Key), a.Value.String())
+}
+
+// writeField encodes a field, using the binary form for values that
+// contain newlines
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// fieldName converts a key into a valid journald field name: uppercase
+// letters, digits and underscores, not starting with an underscore or digit
+func fieldName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := strings.TrimLeft(b.String(), "_0123456789")
+	if name == "" {
+		return "ATTR"
+	}
+	return name
+}
+
+// syslogPriority maps a level to a syslog severity
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
@@ -0,0 +1,171 @@
+// Package logging builds the slog logger shared by all subsystems: text or
+// JSON output, optional journald and syslog sinks, and rate limiting of
+// repeated messages.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Formats accepted by Config.Format
+var Formats = []string{"text", "json"}
+
+// Subsystem names used with For
+const (
+	Watcher = "watcher"
+	Poller  = "poller"
+	Matcher = "matcher"
+	Xattr   = "xattr"
+)
+
+// Config holds logging configuration
+type Config struct {
+	// Level is the minimum level logged; defaults to info
+	Level slog.Leveler
+	// Format is "text" or "json"; defaults to text
+	Format string
+	// Output receives formatted records; defaults to stdout
+	Output io.Writer
+	// Journald also sends records to the native journald socket
+	Journald bool
+	// Syslog also sends records to syslog: "local" for the local daemon,
+	// or a network address such as udp://host:514
+	Syslog string
+	// Tag identifies the program to journald and syslog; defaults to
+	// dbxignore
+	Tag string
+	// RateLimit is how many records with the same level and message are
+	// logged per second before the rest are dropped and counted. Defaults
+	// to 20; negative disables limiting.
+	RateLimit int
+}
+
+// New creates a logger. The returned closer releases the sinks.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	if cfg.Level == nil {
+		cfg.Level = slog.LevelInfo
+	}
+	if cfg.Output == nil {
+		cfg.Output = os.Stdout
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = "dbxignore"
+	}
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = defaultRateLimit
+	}
+
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var (
+		handlers []slog.Handler
+		closers  closers
+	)
+	switch cfg.Format {
+	case "", "text":
+		handlers = append(handlers, slog.NewTextHandler(cfg.Output, opts))
+	case "json":
+		handlers = append(handlers, slog.NewJSONHandler(cfg.Output, opts))
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q, expected one of %s", cfg.Format, strings.Join(Formats, ", "))
+	}
+	if cfg.Journald {
+		h, err := newJournaldHandler(journaldSocket, cfg.Tag, cfg.Level)
+		if err != nil {
+			closers.Close()
+			return nil, nil, fmt.Errorf("failed to connect to journald: %w", err)
+		}
+		handlers = append(handlers, h)
+		closers = append(closers, h)
+	}
+	if cfg.Syslog != "" {
+		h, err := newSyslogHandler(cfg.Syslog, cfg.Tag, cfg.Level)
+		if err != nil {
+			closers.Close()
+			return nil, nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		handlers = append(handlers, h)
+		closers = append(closers, h)
+	}
+
+	h := handlers[0]
+	if len(handlers) > 1 {
+		h = fanout(handlers)
+	}
+	if cfg.RateLimit > 0 {
+		h = newRateLimitHandler(h, cfg.RateLimit)
+	}
+	return slog.New(h), closers, nil
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// For returns the logger of a subsystem
+func For(logger *slog.Logger, subsystem string) *slog.Logger {
+	return logger.With("subsystem", subsystem)
+}
+
+// Discard returns a logger that drops everything
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errs []error
+	for _, closer := range c {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// fanout sends records to several handlers
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
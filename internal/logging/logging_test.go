@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, closer, err := New(Config{Format: "json", Output: &buf, Level: slog.LevelDebug})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer closer.Close()
+
+	For(logger, Poller).Debug("Scan completed", "files", 3)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Output is not JSON: %v\n%s", err, buf.String())
+	}
+	if record["msg"] != "Scan completed" || record["level"] != "DEBUG" ||
+		record["subsystem"] != "poller" || record["files"] != float64(3) {
+		t.Errorf("Unexpected record %v", record)
+	}
+}
+
+func TestNewLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _, err := New(Config{Output: &buf, Level: slog.LevelWarn})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown") {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, expected := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		level, err := ParseLevel(s)
+		if err != nil || level != expected {
+			t.Errorf("ParseLevel(%q) = %v, %v; expected %v", s, level, err, expected)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	h := newRateLimitHandler(slog.NewTextHandler(&buf, nil), 2)
+	now := time.Unix(0, 0)
+	h.limiter.now = func() time.Time { return now }
+	logger := slog.New(h)
+
+	for i := 0; i < 5; i++ {
+		For(logger, Xattr).Info("Set ignore attribute", "path", i)
+	}
+	logger.Info("Other message")
+	if n := strings.Count(buf.String(), "Set ignore attribute"); n != 2 {
+		t.Errorf("Expected 2 records within the window, got %d:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "Other message") {
+		t.Error("Expected a different message to be logged")
+	}
+
+	buf.Reset()
+	now = now.Add(rateLimitWindow)
+	logger.Info("Set ignore attribute", "path", 5)
+	if !strings.Contains(buf.String(), "path=5 suppressed=3") {
+		t.Errorf("Expected the suppressed count, got %q", buf.String())
+	}
+}
+
+func TestFanout(t *testing.T) {
+	var text, js bytes.Buffer
+	h := fanout{
+		slog.NewTextHandler(&text, &slog.HandlerOptions{Level: slog.LevelError}),
+		slog.NewJSONHandler(&js, nil),
+	}
+	logger := slog.New(h).With("subsystem", "watcher")
+	logger.Info("Watching")
+	if text.Len() != 0 {
+		t.Errorf("Expected nothing below the text handler's level, got %q", text.String())
+	}
+	if !strings.Contains(js.String(), `"subsystem":"watcher"`) {
+		t.Errorf("Unexpected JSON output %q", js.String())
+	}
+}
+
+func TestJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Cannot listen on a unix socket: %v", err)
+	}
+	defer conn.Close()
+
+	h, err := newJournaldHandler(socket, "dbxignore", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("newJournaldHandler failed: %v", err)
+	}
+	defer h.Close()
+	logger := slog.New(h).With("subsystem", "xattr").WithGroup("file")
+	logger.Warn("Failed to set xattr", "path", "/a b", "error", "line one\nline two")
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	got := string(buf[:n])
+	for _, field := range []string{
+		"MESSAGE=Failed to set xattr\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=dbxignore\n",
+		"SUBSYSTEM=xattr\n",
+		"FILE_PATH=/a b\n",
+		"FILE_ERROR\n\x11\x00\x00\x00\x00\x00\x00\x00line one\nline two\n",
+	} {
+		if !strings.Contains(got, field) {
+			t.Errorf("Missing field %q in %q", field, got)
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"path":      "PATH",
+		"dry-run":   "DRY_RUN",
+		"_internal": "INTERNAL",
+		"1st":       "ST",
+		"":          "ATTR",
+	} {
+		if got := fieldName(key); got != expected {
+			t.Errorf("fieldName(%q) = %q, expected %q", key, got, expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultRateLimit is how many records with the same level and message
+// are logged per window by default
+const defaultRateLimit = 20
+
+// rateLimitWindow is the period over which records are counted
+const rateLimitWindow = time.Second
+
+type rateKey struct {
+	level   slog.Level
+	message string
+}
+
+type rateCount struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+// rateLimiter is shared by a handler and those derived from it, so that
+// subsystem loggers count against the same limit
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	counts map[rateKey]*rateCount
+	now    func() time.Time
+}
+
+// allow reports whether a record may be logged and how many records with
+// the same key were dropped since the last one logged
+func (l *rateLimiter) allow(key rateKey) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	c, ok := l.counts[key]
+	if !ok {
+		c = &rateCount{start: now}
+		l.counts[key] = c
+	}
+	if now.Sub(c.start) >= rateLimitWindow {
+		c.start = now
+		c.logged = 0
+		// Forget quiet keys so the map does not grow without bound
+		for k, other := range l.counts {
+			if other.suppressed == 0 && now.Sub(other.start) >= rateLimitWindow {
+				delete(l.counts, k)
+			}
+		}
+	}
+	if c.logged >= l.limit {
+		c.suppressed++
+		return false, 0
+	}
+	c.logged++
+	suppressed := c.suppressed
+	c.suppressed = 0
+	return true, suppressed
+}
+
+// rateLimitHandler drops records once the same level and message has been
+// logged limit times in a window, such as a per-file message during a scan
+// of a large tree. The next record logged reports how many were dropped.
+type rateLimitHandler struct {
+	next    slog.Handler
+	limiter *rateLimiter
+}
+
+func newRateLimitHandler(next slog.Handler, limit int) *rateLimitHandler {
+	return &rateLimitHandler{
+		next: next,
+		limiter: &rateLimiter{
+			limit:  limit,
+			counts: make(map[rateKey]*rateCount),
+			now:    time.Now,
+		},
+	}
+}
+
+func (h *rateLimitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *rateLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	ok, suppressed := h.limiter.allow(rateKey{r.Level, r.Message})
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *rateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &rateLimitHandler{next: h.next.WithAttrs(attrs), limiter: h.limiter}
+}
+
+func (h *rateLimitHandler) WithGroup(name string) slog.Handler {
+	return &rateLimitHandler{next: h.next.WithGroup(name), limiter: h.limiter}
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// syslogWriter is shared by a handler and those derived from it
+type syslogWriter struct {
+	mu sync.Mutex
+	w  *syslog.Writer
+}
+
+// syslogHandler sends records to syslog. The message and attributes are
+// formatted like the text handler; time and level are left to syslog.
+type syslogHandler struct {
+	out   *syslogWriter
+	level slog.Leveler
+	// text formats records into buf, which is guarded by out.mu
+	text slog.Handler
+	buf  *bytes.Buffer
+}
+
+// newSyslogHandler connects to syslog at addr: "local" for the local
+// daemon, or a URL such as udp://host:514 or tcp://host:514
+func newSyslogHandler(addr, tag string, level slog.Leveler) (*syslogHandler, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp") {
+			return nil, fmt.Errorf("invalid syslog address %q, expected local, udp://host:port or tcp://host:port", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	return &syslogHandler{
+		out:   &syslogWriter{w: w},
+		level: level,
+		text:  slog.NewTextHandler(buf, &slog.HandlerOptions{Level: level, ReplaceAttr: dropTimeAndLevel}),
+		buf:   buf,
+	}, nil
+}
+
+func dropTimeAndLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+		return slog.Attr{}
+	}
+	return a
+}
+
+func (h *syslogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.buf.Reset()
+	if err := h.text.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch syslogPriority(r.Level) {
+	case 3:
+		return h.out.w.Err(msg)
+	case 4:
+		return h.out.w.Warning(msg)
+	case 6:
+		return h.out.w.Info(msg)
+	default:
+		return h.out.w.Debug(msg)
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.text = h.text.WithAttrs(attrs)
+	return &clone
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.text = h.text.WithGroup(name)
+	return &clone
+}
+
+func (h *syslogHandler) Close() error {
+	return h.out.w.Close()
+}
@@ -0,0 +1,452 @@
+// Package lsp implements a Language Server Protocol server for
+// .dropboxignore and .dropboxinclude files. It speaks JSON-RPC over a pair
+// of streams, normally stdin and stdout, and evaluates documents with the
+// same matcher the daemon uses, so editors and the daemon always agree.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+const (
+	ignoreFileName  = ".dropboxignore"
+	includeFileName = ".dropboxinclude"
+	testFileSuffix  = ".test"
+)
+
+// Server is a language server for ignore files
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	outMu   sync.Mutex
+	matcher *matcher.Matcher
+	logger  *slog.Logger
+
+	docs     map[string]*document
+	shutdown bool
+}
+
+// document is an open editor buffer
+type document struct {
+	path string
+	text string
+	// matches caches matcher.RuleMatches until the buffer changes
+	matches map[int]int
+}
+
+// Config holds server configuration
+type Config struct {
+	In  io.Reader
+	Out io.Writer
+	// Matcher evaluates documents; it receives their unsaved content as
+	// overlays. Defaults to a new matcher.
+	Matcher *matcher.Matcher
+	Logger  *slog.Logger
+}
+
+// NewServer creates a language server
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.Matcher == nil {
+		m, err := matcher.NewMatcherWithConfig(matcher.Config{Logger: cfg.Logger})
+		if err != nil {
+			return nil, err
+		}
+		cfg.Matcher = m
+	}
+
+	return &Server{
+		in:      bufio.NewReader(cfg.In),
+		out:     cfg.Out,
+		matcher: cfg.Matcher,
+		logger:  cfg.Logger,
+		docs:    make(map[string]*document),
+	}, nil
+}
+
+// Run serves requests until the client sends exit or closes the stream
+func (s *Server) Run() error {
+	for {
+		req, err := readMessage(s.in)
+		if err != nil {
+			var rerr *responseError
+			if errors.As(err, &rerr) {
+				s.reply(nil, nil, rerr)
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(req)
+		if req.ID != nil {
+			s.reply(req.ID, result, rerr)
+		} else if rerr != nil {
+			s.logger.Warn("LSP notification failed", "method", req.Method, "error", rerr.Message)
+		}
+	}
+}
+
+// handle dispatches a request or notification
+func (s *Server) handle(req *message) (any, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    1, // full document
+					"save":      true,
+				},
+				"hoverProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"(", "[", "#", " "},
+				},
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "dbxignore"},
+		}, nil
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// Only full document sync is advertised
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.update(params.TextDocument.URI, text)
+
+	case "textDocument/didSave":
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			// Other files may have changed on disk too
+			doc.matches = nil
+			s.publishDiagnostics(params.TextDocument.URI, doc)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			s.matcher.Overlay(doc.path, nil)
+			delete(s.docs, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+				URI:         params.TextDocument.URI,
+				Diagnostics: []diagnostic{},
+			})
+		}
+		return nil, nil
+
+	case "textDocument/hover":
+		doc, pos, rerr := s.positionParams(req.Params)
+		if rerr != nil || doc == nil {
+			return nil, rerr
+		}
+		return s.hover(doc, pos), nil
+
+	case "textDocument/completion":
+		doc, pos, rerr := s.positionParams(req.Params)
+		if rerr != nil || doc == nil {
+			return nil, rerr
+		}
+		return s.completion(doc, pos), nil
+
+	case "textDocument/definition":
+		doc, pos, rerr := s.positionParams(req.Params)
+		if rerr != nil || doc == nil {
+			return nil, rerr
+		}
+		return s.definition(doc, pos), nil
+	}
+
+	if req.ID == nil {
+		// Unknown notifications are ignored
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// update stores new buffer content, hands it to the matcher and publishes
+// fresh diagnostics
+func (s *Server) update(uri, text string) *responseError {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return invalidParams(err)
+	}
+	doc := &document{path: path, text: text}
+	s.docs[uri] = doc
+	s.matcher.Overlay(path, []byte(text))
+	s.publishDiagnostics(uri, doc)
+	return nil
+}
+
+// publishDiagnostics lints a document. Rule files get the full linter,
+// companion .test files only their expectations.
+func (s *Server) publishDiagnostics(uri string, doc *document) {
+	var (
+		diags []matcher.Diagnostic
+		err   error
+	)
+	switch name := filepath.Base(doc.path); {
+	case name == ignoreFileName || name == includeFileName:
+		diags, err = s.matcher.LintFile(doc.path)
+	case strings.HasSuffix(doc.path, testFileSuffix):
+		_, diags, err = s.matcher.Test(strings.TrimSuffix(doc.path, testFileSuffix))
+	}
+	if err != nil {
+		s.logger.Warn("Failed to lint", "path", doc.path, "error", err)
+	}
+
+	params := publishDiagnosticsParams{URI: uri, Diagnostics: []diagnostic{}}
+	for _, d := range diags {
+		if d.File != doc.path {
+			continue
+		}
+		severity := severityWarning
+		if d.Severity == matcher.SeverityError {
+			severity = severityError
+		}
+		params.Diagnostics = append(params.Diagnostics, diagnostic{
+			Range:    lineRange(doc.text, d.Line-1),
+			Severity: severity,
+			Source:   "dbxignore",
+			Message:  d.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", params)
+}
+
+// hover describes the rule or expectation under the cursor
+func (s *Server) hover(doc *document, pos position) any {
+	line := strings.TrimSpace(lineAt(doc.text, pos.Line))
+	if line == "" {
+		return nil
+	}
+
+	var text string
+	if path, ignored, ok := s.parseExpectation(doc, line); ok {
+		text = s.describeExpectation(doc, path, ignored)
+	} else if line[0] == '#' || strings.HasSuffix(doc.path, testFileSuffix) {
+		return nil
+	} else {
+		if doc.matches == nil {
+			matches, err := s.matcher.RuleMatches(doc.path)
+			if err != nil {
+				s.logger.Warn("Failed to count matches", "path", doc.path, "error", err)
+				return nil
+			}
+			doc.matches = matches
+		}
+		n, ok := doc.matches[pos.Line+1]
+		switch {
+		case !ok:
+			// Section headers, invalid rules and rules for other hosts
+			return nil
+		case n == 1:
+			text = fmt.Sprintf("`%s` matches 1 path in the current tree", line)
+		default:
+			text = fmt.Sprintf("`%s` matches %d paths in the current tree", line, n)
+		}
+	}
+
+	r := lineRange(doc.text, pos.Line)
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// parseExpectation parses an expectation line. Rule files only carry them
+// in comments, companion .test files also as bare lines.
+func (s *Server) parseExpectation(doc *document, line string) (string, bool, bool) {
+	if !strings.HasPrefix(line, "#") && !strings.HasSuffix(doc.path, testFileSuffix) {
+		return "", false, false
+	}
+	return matcher.ParseExpectation(line)
+}
+
+// describeExpectation evaluates an expectation for hover text
+func (s *Server) describeExpectation(doc *document, path string, ignored bool) string {
+	res, err := s.explain(doc, path)
+	if err != nil {
+		return fmt.Sprintf("`%s`: %v", path, err)
+	}
+	verdict := "holds"
+	if res.Ignored != ignored {
+		verdict = "fails"
+	}
+	switch {
+	case !res.Ignored:
+		return fmt.Sprintf("`%s` is synced; expectation %s", path, verdict)
+	case res.Rule == nil:
+		return fmt.Sprintf("`%s` is ignored by default: not listed in %s; expectation %s", path, res.IncludeFile, verdict)
+	default:
+		return fmt.Sprintf("`%s` is ignored by %s:%d: `%s`; expectation %s", path, res.Rule.File, res.Rule.Line, res.Rule.Pattern, verdict)
+	}
+}
+
+// explain evaluates a path relative to a document's rule file
+func (s *Server) explain(doc *document, path string) (matcher.Result, error) {
+	base := filepath.Dir(doc.path)
+	abs := filepath.Join(base, filepath.FromSlash(strings.TrimSuffix(path, "/")))
+	return s.matcher.Match(abs, matcher.VirtualEntry(path))
+}
+
+// definition jumps from an expectation to the rule that decides its path
+func (s *Server) definition(doc *document, pos position) any {
+	path, _, ok := s.parseExpectation(doc, strings.TrimSpace(lineAt(doc.text, pos.Line)))
+	if !ok {
+		return nil
+	}
+	res, err := s.explain(doc, path)
+	if err != nil || !res.Ignored {
+		return nil
+	}
+	if res.Rule == nil {
+		return location{URI: pathToURI(res.IncludeFile)}
+	}
+	line := res.Rule.Line - 1
+	return location{
+		URI: pathToURI(res.Rule.File),
+		Range: lspRange{
+			Start: position{Line: line},
+			End:   position{Line: line, Character: utf16Len(res.Rule.Pattern)},
+		},
+	}
+}
+
+// completion offers qualifiers inside a leading (...) group, section
+// conditions inside a header and directives at the start of a line
+func (s *Server) completion(doc *document, pos position) any {
+	prefix := lineAt(doc.text, pos.Line)
+	if pos.Character < len(prefix) {
+		prefix = prefix[:pos.Character]
+	}
+	trimmed := strings.TrimPrefix(strings.TrimLeft(prefix, " "), "!")
+
+	items := []completionItem{}
+	switch {
+	case strings.HasPrefix(trimmed, "(") && !strings.Contains(trimmed, ")"):
+		for _, name := range matcher.Qualifiers() {
+			items = append(items, completionItem{Label: name, Kind: kindEnum, Detail: "qualifier"})
+		}
+		items = append(items,
+			completionItem{Label: "size>", Kind: kindKeyword, Detail: "size predicate, e.g. size>500M"},
+			completionItem{Label: "age>", Kind: kindKeyword, Detail: "age predicate, e.g. age>90d"},
+			completionItem{Label: "mtime<", Kind: kindKeyword, Detail: "modification time predicate, e.g. mtime<2024-01-01"},
+		)
+
+	case strings.HasPrefix(trimmed, "[") && !strings.Contains(trimmed, "]"):
+		items = append(items,
+			completionItem{Label: "host:", Kind: kindKeyword, Detail: "rules for a host name glob"},
+			completionItem{Label: "os:", Kind: kindKeyword, Detail: "rules for an operating system"},
+			completionItem{Label: "user:", Kind: kindKeyword, Detail: "rules for a user name glob"},
+			completionItem{Label: "*]", Kind: kindKeyword, Detail: "end of conditional rules"},
+		)
+
+	case strings.HasPrefix(trimmed, "#"):
+		items = append(items,
+			completionItem{Label: "expect-ignored:", Kind: kindKeyword, Detail: "assert that a path is ignored", InsertText: "expect-ignored: "},
+			completionItem{Label: "expect-synced:", Kind: kindKeyword, Detail: "assert that a path is synced", InsertText: "expect-synced: "},
+		)
+
+	case strings.TrimSpace(prefix) == "":
+		items = append(items,
+			completionItem{Label: "[host:]", Kind: kindSnippet, Detail: "section for a host"},
+			completionItem{Label: "[os:]", Kind: kindSnippet, Detail: "section for an operating system"},
+			completionItem{Label: "[user:]", Kind: kindSnippet, Detail: "section for a user"},
+			completionItem{Label: "[*]", Kind: kindSnippet, Detail: "end of conditional rules"},
+			completionItem{Label: "re:", Kind: kindKeyword, Detail: "RE2 regular expression"},
+			completionItem{Label: "# expect-ignored: ", Kind: kindSnippet, Detail: "assert that a path is ignored"},
+			completionItem{Label: "# expect-synced: ", Kind: kindSnippet, Detail: "assert that a path is synced"},
+		)
+	}
+	return items
+}
+
+// positionParams decodes hover, completion and definition parameters. The
+// document is nil when it is not open.
+func (s *Server) positionParams(raw json.RawMessage) (*document, position, *responseError) {
+	var params positionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, position{}, invalidParams(err)
+	}
+	return s.docs[params.TextDocument.URI], params.Position, nil
+}
+
+// reply sends a response
+func (s *Server) reply(id *json.RawMessage, result any, rerr *responseError) {
+	msg := &message{ID: id, Error: rerr}
+	if rerr == nil {
+		// A successful response always carries a result, if only null
+		raw, err := json.Marshal(result)
+		if err != nil {
+			s.logger.Error("Failed to encode response", "error", err)
+			raw = []byte("null")
+		}
+		msg.Result = raw
+	}
+	s.send(msg)
+}
+
+// notify sends a notification
+func (s *Server) notify(method string, params any) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		s.logger.Error("Failed to encode notification", "method", method, "error", err)
+		return
+	}
+	s.send(&message{Method: method, Params: raw})
+}
+
+func (s *Server) send(msg *message) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	if err := writeMessage(s.out, msg); err != nil {
+		s.logger.Error("Failed to write LSP message", "error", err)
+	}
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
@@ -0,0 +1,177 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// client drives a Server over in-memory pipes
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	s, err := NewServer(Config{In: serverR, Out: serverW, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	c := &client{t: t, w: clientW, r: bufio.NewReader(clientR), done: make(chan error, 1)}
+	go func() {
+		c.done <- s.Run()
+		serverW.Close()
+	}()
+	return c
+}
+
+func (c *client) send(method string, id int, params any) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		c.t.Fatalf("Failed to encode params: %v", err)
+	}
+	msg := &message{Method: method, Params: raw}
+	if id != 0 {
+		rawID, _ := json.Marshal(id)
+		msg.ID = (*json.RawMessage)(&rawID)
+	}
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatalf("Failed to send %s: %v", method, err)
+	}
+}
+
+// notify sends a notification
+func (c *client) notify(method string, params any) {
+	c.send(method, 0, params)
+}
+
+// call sends a request and decodes its result into out
+func (c *client) call(method string, params, out any) {
+	c.nextID++
+	c.send(method, c.nextID, params)
+	for {
+		msg, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatalf("Failed to read response to %s: %v", method, err)
+		}
+		if msg.ID == nil {
+			// Skip notifications
+			continue
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s failed: %s", method, msg.Error.Message)
+		}
+		if err := json.Unmarshal(msg.Result, out); err != nil {
+			c.t.Fatalf("Failed to decode result of %s: %v", method, err)
+		}
+		return
+	}
+}
+
+// diagnostics waits for the next publishDiagnostics notification
+func (c *client) diagnostics() publishDiagnosticsParams {
+	for {
+		msg, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatalf("Failed to read diagnostics: %v", err)
+		}
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params publishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			c.t.Fatalf("Failed to decode diagnostics: %v", err)
+		}
+		return params
+	}
+}
+
+func TestServer(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "build/out.o"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	ignoreFile := filepath.Join(tmpDir, ".dropboxignore")
+	if err := os.WriteFile(ignoreFile, []byte("*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	uri := pathToURI(ignoreFile)
+
+	c := newClient(t)
+	var init map[string]any
+	c.call("initialize", map[string]any{}, &init)
+	if _, ok := init["capabilities"]; !ok {
+		t.Fatalf("Expected capabilities, got %v", init)
+	}
+	c.notify("initialized", map[string]any{})
+
+	// The unsaved buffer is linted, not the file on disk
+	text := "*.log\nbuild/\nre:(\n# expect-synced: build/out.o\n(\n"
+	c.notify("textDocument/didOpen", didOpenParams{TextDocument: textDocumentItem{URI: uri, Text: text}})
+	diags := c.diagnostics()
+	if diags.URI != uri {
+		t.Errorf("Expected diagnostics for %s, got %s", uri, diags.URI)
+	}
+	// An invalid regex, a failing expectation and a rule matching nothing
+	expected := map[int]int{2: severityError, 3: severityError, 4: severityWarning}
+	if len(diags.Diagnostics) != len(expected) {
+		t.Errorf("Expected %d diagnostics, got %v", len(expected), diags.Diagnostics)
+	}
+	for _, d := range diags.Diagnostics {
+		if severity, ok := expected[d.Range.Start.Line]; !ok || severity != d.Severity {
+			t.Errorf("Unexpected diagnostic on line %d with severity %d: %s", d.Range.Start.Line, d.Severity, d.Message)
+		}
+	}
+
+	var h hover
+	c.call("textDocument/hover", positionParams{TextDocument: textDocumentIdentifier{URI: uri}, Position: position{Line: 0}}, &h)
+	if !strings.Contains(h.Contents.Value, "matches 2 paths") {
+		t.Errorf("Unexpected hover text %q", h.Contents.Value)
+	}
+	c.call("textDocument/hover", positionParams{TextDocument: textDocumentIdentifier{URI: uri}, Position: position{Line: 3}}, &h)
+	if !strings.Contains(h.Contents.Value, "expectation fails") {
+		t.Errorf("Unexpected hover text %q", h.Contents.Value)
+	}
+
+	var loc location
+	c.call("textDocument/definition", positionParams{TextDocument: textDocumentIdentifier{URI: uri}, Position: position{Line: 3}}, &loc)
+	if loc.URI != uri || loc.Range.Start.Line != 1 {
+		t.Errorf("Expected definition at line 1 of %s, got %+v", uri, loc)
+	}
+
+	var items []completionItem
+	c.call("textDocument/completion", positionParams{TextDocument: textDocumentIdentifier{URI: uri}, Position: position{Line: 4, Character: 1}}, &items)
+	labels := make(map[string]bool)
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, want := range []string{"dir", "video", "size>"} {
+		if !labels[want] {
+			t.Errorf("Expected completion %q, got %v", want, items)
+		}
+	}
+
+	var result any
+	c.call("shutdown", nil, &result)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package matcher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// testFileSuffix names the companion file holding a rule file's expectations
+const testFileSuffix = ".test"
+
+// expectRe matches assertions such as "# expect-ignored: build/out.o"
+var expectRe = regexp.MustCompile(`^#?\s*expect-(ignored|synced)\s*:\s*(.+)$`)
+
+// expectation is a single assertion about a path relative to a rule file's
+// directory; a trailing slash marks a directory
+type expectation struct {
+	file    string
+	line    int
+	path    string
+	ignored bool
+}
+
+// Test evaluates the expectations written next to the rules of an ignore
+// file as "# expect-ignored: path" and "# expect-synced: path" comments,
+// or in a companion file with a .test suffix, and reports the ones that
+// do not hold along with how many were checked. Expectations under a
+// section header for another host are skipped. The paths are evaluated
+// without touching them on disk, so predicates never match.
+func (m *Matcher) Test(file string) (int, []Diagnostic, error) {
+	expectations, diags, err := m.loadExpectations(file, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	companion, companionDiags, err := m.loadExpectations(file+testFileSuffix, true)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, nil, err
+	}
+	expectations = append(expectations, companion...)
+	diags = append(diags, companionDiags...)
+
+	base := filepath.Dir(file)
+	for _, e := range expectations {
+		res, err := m.Match(filepath.Join(base, filepath.FromSlash(strings.TrimSuffix(e.path, "/"))), VirtualEntry(e.path))
+		if err != nil {
+			return 0, nil, err
+		}
+		if res.Ignored == e.ignored {
+			continue
+		}
+		msg := fmt.Sprintf("expected %s to be synced, but it is ignored by %s", e.path, describeResult(res, file))
+		if e.ignored {
+			msg = fmt.Sprintf("expected %s to be ignored, but it is synced", e.path)
+		}
+		diags = append(diags, Diagnostic{File: e.file, Line: e.line, Message: msg, Severity: SeverityError})
+	}
+	return len(expectations), diags, nil
+}
+
+// loadExpectations reads the expectations of a rule file or its companion.
+// In a companion file every line that is not blank or a comment must be an
+// expectation.
+func (m *Matcher) loadExpectations(file string, companion bool) ([]expectation, []Diagnostic, error) {
+	f, err := m.openFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var (
+		expectations []expectation
+		diags        []Diagnostic
+	)
+	current := section{}
+	lineNo := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if isSectionHeader(line) {
+			current = parseSection(line)
+			continue
+		}
+
+		path, ignored, ok := ParseExpectation(line)
+		if !ok {
+			if companion && line != "" && line[0] != '#' {
+				diags = append(diags, Diagnostic{File: file, Line: lineNo, Message: fmt.Sprintf("unknown expectation %q", line), Severity: SeverityError})
+			}
+			continue
+		}
+		// Outside a companion file only comments carry expectations
+		if !companion && line[0] != '#' {
+			continue
+		}
+		if !current.matches(m.host) {
+			continue
+		}
+		expectations = append(expectations, expectation{
+			file:    file,
+			line:    lineNo,
+			path:    path,
+			ignored: ignored,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return expectations, diags, nil
+}
+
+// ParseExpectation parses an expect-ignored or expect-synced line, with or
+// without a leading '#'
+func ParseExpectation(line string) (path string, ignored bool, ok bool) {
+	match := expectRe.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return "", false, false
+	}
+	return match[2], match[1] == "ignored", true
+}
+
+// describeResult explains why a path is ignored, naming the line alone
+// when the rule comes from file
+func describeResult(res Result, file string) string {
+	if res.Rule == nil {
+		return "default: not listed in " + res.IncludeFile
+	}
+	if res.Rule.File == file {
+		return fmt.Sprintf("line %d: %s", res.Rule.Line, res.Rule.Pattern)
+	}
+	return fmt.Sprintf("%s:%d: %s", res.Rule.File, res.Rule.Line, res.Rule.Pattern)
+}
+
+// VirtualEntry describes a path that need not exist for Match: a directory
+// when it ends in a slash, a regular file otherwise
+func VirtualEntry(p string) fs.DirEntry {
+	e := entry{name: path.Base(strings.TrimSuffix(p, "/"))}
+	if strings.HasSuffix(p, "/") {
+		e.typ = fs.ModeDir
+	}
+	return e
+}
+
+// entry is a directory entry without metadata
+type entry struct {
+	name string
+	typ  fs.FileMode
+}
+
+func (e entry) Name() string               { return e.name }
+func (e entry) IsDir() bool                { return e.typ.IsDir() }
+func (e entry) Type() fs.FileMode          { return e.typ }
+func (e entry) Info() (fs.FileInfo, error) { return nil, fs.ErrNotExist }
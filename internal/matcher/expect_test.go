@@ -0,0 +1,68 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherTest(t *testing.T) {
+	tmpDir := t.TempDir()
+	ignoreFile := filepath.Join(tmpDir, ".dropboxignore")
+
+	content := "build/\n" +
+		"# expect-ignored: build/out.o\n" +
+		"# expect-synced: src/build.go\n" +
+		"*.go\n" +
+		"[os:plan9]\n" +
+		"# expect-synced: main.go\n" +
+		"[*]\n" +
+		"# expect-ignored: node_modules/\n"
+	if err := os.WriteFile(ignoreFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	companion := "# Companion expectations\n" +
+		"expect-ignored: build/\n" +
+		"expect-synced: docs/readme.md\n" +
+		"expect-ignored: docs/readme.md\n" +
+		"ignored: oops\n"
+	if err := os.WriteFile(ignoreFile+".test", []byte(companion), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	m, err := NewMatcherWithConfig(Config{Host: Host{OS: "linux"}})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	checked, diags, err := m.Test(ignoreFile)
+	if err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+
+	// The plan9 expectation is skipped on this host
+	if checked != 6 {
+		t.Errorf("Expected 6 expectations, got %d", checked)
+	}
+	expected := []Diagnostic{
+		{ignoreFile + ".test", 5, `unknown expectation "ignored: oops"`, SeverityError},
+		{ignoreFile, 3, "expected src/build.go to be synced, but it is ignored by line 4: *.go", SeverityError},
+		{ignoreFile, 8, "expected node_modules/ to be ignored, but it is synced", SeverityError},
+		{ignoreFile + ".test", 4, "expected docs/readme.md to be ignored, but it is synced", SeverityError},
+	}
+	if len(diags) != len(expected) {
+		for _, d := range diags {
+			t.Log(d)
+		}
+		t.Fatalf("Expected %d diagnostics, got %d", len(expected), len(diags))
+	}
+	for i, d := range diags {
+		if d != expected[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, expected[i], d)
+		}
+	}
+
+	// None of the paths were created
+	if _, err := os.Stat(filepath.Join(tmpDir, "build")); !os.IsNotExist(err) {
+		t.Error("Expected build/ not to exist")
+	}
+}
@@ -0,0 +1,154 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/gitrepo"
+)
+
+const gitIgnoreFileName = ".gitignore"
+
+// cachedIndex is a parsed git index along with the stat data used to
+// notice when git rewrites it
+type cachedIndex struct {
+	index   *gitrepo.Index
+	modTime time.Time
+	size    int64
+}
+
+// gitLayer is one source of git ignore rules together with the directory
+// its patterns are relative to
+type gitLayer struct {
+	base string
+	file *IgnoreFile
+}
+
+// explainGit evaluates git's ignore rules for a path inside a work tree.
+// Sources are consulted from highest to lowest precedence: .gitignore files
+// from the path's directory up to the work tree root, .git/info/exclude and
+// finally core.excludesFile. The first source with a matching rule decides,
+// and as in git a path inside an ignored directory is ignored too.
+func (m *Matcher) explainGit(path string, t target) (Result, error) {
+	repo, ok := gitrepo.Find(filepath.Dir(path))
+	if !ok {
+		return Result{}, nil
+	}
+
+	rel, err := filepath.Rel(repo.Root, path)
+	if err != nil {
+		return Result{}, err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return Result{}, nil
+	}
+
+	layers, err := m.gitLayers(repo, filepath.Dir(path))
+	if err != nil {
+		return Result{}, err
+	}
+	lastMatch := func(t target) *Rule {
+		return lastLayerMatch(layers, repo.Root, t)
+	}
+	t.rel = rel
+	rule := decide(t, repo.Root, lastMatch)
+	if rule == nil || rule.Negate {
+		return Result{}, nil
+	}
+
+	// Tracked files, and directories holding them, stay in Dropbox
+	index, err := m.gitIndex(repo)
+	if err != nil {
+		return Result{}, err
+	}
+	if index.Tracked(rel) {
+		return Result{}, nil
+	}
+
+	return Result{Ignored: true, Rule: rule}, nil
+}
+
+// gitLayers loads the ignore sources that can apply to entries of dir,
+// highest precedence first
+func (m *Matcher) gitLayers(repo *gitrepo.Repo, dir string) ([]gitLayer, error) {
+	var layers []gitLayer
+	add := func(file, base string) error {
+		if _, err := os.Stat(file); err != nil {
+			return nil
+		}
+		ignore, err := m.getOrLoadIgnore(file)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, gitLayer{base: base, file: ignore})
+		return nil
+	}
+
+	for ; ; dir = filepath.Dir(dir) {
+		if err := add(filepath.Join(dir, gitIgnoreFileName), dir); err != nil {
+			return nil, err
+		}
+		if dir == repo.Root || len(dir) < len(repo.Root) {
+			break
+		}
+	}
+	for _, file := range []string{repo.InfoExcludeFile(), repo.ExcludesFile()} {
+		if file == "" {
+			continue
+		}
+		if err := add(file, repo.Root); err != nil {
+			return nil, err
+		}
+	}
+	return layers, nil
+}
+
+// lastLayerMatch returns the highest-precedence rule matching an entry
+// whose path is relative to the work tree root. A .gitignore only applies
+// to paths below its own directory.
+func lastLayerMatch(layers []gitLayer, root string, t target) *Rule {
+	path := filepath.Join(root, filepath.FromSlash(t.rel))
+	parent := filepath.Dir(path)
+	for _, layer := range layers {
+		if parent != layer.base && !strings.HasPrefix(parent, layer.base+string(filepath.Separator)) {
+			continue
+		}
+		layerRel, err := filepath.Rel(layer.base, path)
+		if err != nil {
+			continue
+		}
+		t.rel = filepath.ToSlash(layerRel)
+		if rule := layer.file.lastMatch(t); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// gitIndex returns the tracked paths of a repository, re-reading the index
+// whenever git has rewritten it
+func (m *Matcher) gitIndex(repo *gitrepo.Repo) (*gitrepo.Index, error) {
+	stat, err := os.Stat(repo.IndexFile())
+	var modTime time.Time
+	var size int64
+	if err == nil {
+		modTime, size = stat.ModTime(), stat.Size()
+	}
+
+	m.gitMu.Lock()
+	defer m.gitMu.Unlock()
+
+	if cached, ok := m.indexes[repo.Root]; ok && cached.modTime.Equal(modTime) && cached.size == size {
+		return cached.index, nil
+	}
+
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	m.indexes[repo.Root] = &cachedIndex{index: index, modTime: modTime, size: size}
+	return index, nil
+}
@@ -0,0 +1,136 @@
+package matcher
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherGitIgnore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	tmpDir := t.TempDir()
+	repo := filepath.Join(tmpDir, "project")
+	files := map[string]string{
+		"project/.gitignore":        "*.o\nbuild/\nvendor.lock\n",
+		"project/sub/.gitignore":    "!keep.o\n",
+		"project/.git/info/exclude": "local-notes.txt\n",
+		"project/main.go":           "package main",
+		"project/main.o":            "",
+		"project/sub/keep.o":        "",
+		"project/sub/drop.o":        "",
+		"project/build/out":         "",
+		"project/vendor.lock":       "",
+		"project/local-notes.txt":   "",
+		"project/scratch.swp":       "",
+		"outside/main.o":            "",
+	}
+
+	cmd := exec.Command("git", "init", "-q", repo)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	globalIgnore := filepath.Join(home, ".config", "git", "ignore")
+	os.MkdirAll(filepath.Dir(globalIgnore), 0755)
+	if err := os.WriteFile(globalIgnore, []byte("*.swp\n"), 0644); err != nil {
+		t.Fatalf("Failed to create global ignore file: %v", err)
+	}
+
+	// vendor.lock matches a pattern but is tracked
+	cmd = exec.Command("git", "add", "-f", "main.go", "vendor.lock")
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git add failed: %v\n%s", err, out)
+	}
+
+	m, err := NewMatcherWithConfig(Config{GitIgnore: true})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	off, err := NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		ignore bool
+	}{
+		{"project/main.go", false},
+		{"project/main.o", true},
+		{"project/sub/drop.o", true},
+		{"project/sub/keep.o", false}, // Negated in deeper .gitignore
+		{"project/build", true},       // Directory-only pattern
+		{"project/build/out", true},
+		{"project/vendor.lock", false}, // Tracked
+		{"project/local-notes.txt", true},
+		{"project/scratch.swp", true}, // core.excludesFile default
+		{"outside/main.o", false},     // Not in a work tree
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tmpDir, tt.path)
+		got, err := m.ShouldIgnore(path)
+		if err != nil {
+			t.Errorf("Error checking %s: %v", tt.path, err)
+			continue
+		}
+		if got != tt.ignore {
+			t.Errorf("Path %s: expected ignore=%v, got %v", tt.path, tt.ignore, got)
+		}
+
+		// Without the opt-in, git rules are not consulted
+		if got, _ := off.ShouldIgnore(path); got {
+			t.Errorf("Path %s: ignored with GitIgnore disabled", tt.path)
+		}
+	}
+
+	res, _ := m.Explain(filepath.Join(repo, "local-notes.txt"))
+	if res.Rule == nil || res.Rule.File != filepath.Join(repo, ".git", "info", "exclude") {
+		t.Errorf("Expected rule from info/exclude, got %+v", res.Rule)
+	}
+}
+
+func TestMatcherGitIgnoreDropboxNegation(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	repo := t.TempDir()
+	cmd := exec.Command("git", "init", "-q", repo)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+	os.WriteFile(filepath.Join(repo, ".gitignore"), []byte(".env\n*.log\n"), 0644)
+	os.WriteFile(filepath.Join(repo, ".dropboxignore"), []byte("!.env\n"), 0644)
+
+	m, err := NewMatcherWithConfig(Config{GitIgnore: true})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+
+	// An explicit .dropboxignore negation keeps the file in Dropbox
+	if got, _ := m.ShouldIgnore(filepath.Join(repo, ".env")); got {
+		t.Error(".env should not be ignored")
+	}
+	if got, _ := m.ShouldIgnore(filepath.Join(repo, "debug.log")); !got {
+		t.Error("debug.log should be ignored")
+	}
+}
@@ -0,0 +1,148 @@
+package matcher
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// Host describes the machine that conditional sections are evaluated against
+type Host struct {
+	Hostname string
+	OS       string
+	User     string
+}
+
+// CurrentHost returns the Host of the running process
+func CurrentHost() Host {
+	h := Host{OS: runtime.GOOS}
+	if name, err := os.Hostname(); err == nil {
+		h.Hostname = name
+	}
+	if u, err := user.Current(); err == nil {
+		h.User = u.Username
+	} else {
+		h.User = os.Getenv("USER")
+	}
+	return h
+}
+
+// condition is a single key:value test inside a section header
+type condition struct {
+	key   string
+	value string
+}
+
+// section is a parsed header such as [host:build-box] or [os:darwin user:alice].
+// Rules following a header only apply when every condition holds; [*] starts
+// an unconditional block again.
+type section struct {
+	text       string
+	conditions []condition
+}
+
+// isSectionHeader reports whether a trimmed line is a section header.
+// Only [*] and bracketed key:value lists qualify, so glob patterns that
+// start with a character class like [Bb]uild are left alone.
+func isSectionHeader(line string) bool {
+	if len(line) < 3 || line[0] != '[' || line[len(line)-1] != ']' {
+		return false
+	}
+	inner := line[1 : len(line)-1]
+	return inner == "*" || strings.Contains(inner, ":")
+}
+
+// SectionAtEnd returns the header of the conditional section that lines
+// appended to a rule file's content fall under, or "" when they would
+// apply on every machine. Appending [*] first makes them unconditional.
+func SectionAtEnd(content string) string {
+	var open string
+	forEachLine(content, func(line, header string) {
+		open = header
+	})
+	return open
+}
+
+// UnconditionalLines returns the trimmed lines of a rule file's content
+// that are outside conditional sections, headers left out
+func UnconditionalLines(content string) []string {
+	var lines []string
+	forEachLine(content, func(line, header string) {
+		if header == "" && !isSectionHeader(line) {
+			lines = append(lines, line)
+		}
+	})
+	return lines
+}
+
+// forEachLine calls fn with each trimmed line of a rule file's content and
+// the header of the conditional section it falls under, if any
+func forEachLine(content string, fn func(line, header string)) {
+	header := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if isSectionHeader(line) {
+			header = ""
+			if len(parseSection(line).conditions) > 0 {
+				header = line
+			}
+		}
+		fn(line, header)
+	}
+}
+
+// parseSection parses a section header line. Unknown keys are kept so the
+// section simply never matches on this version instead of failing the file.
+func parseSection(line string) section {
+	s := section{text: line}
+	inner := strings.TrimSpace(line[1 : len(line)-1])
+	if inner == "*" {
+		return s
+	}
+	for _, field := range strings.Fields(inner) {
+		key, value, _ := strings.Cut(field, ":")
+		s.conditions = append(s.conditions, condition{
+			key:   strings.ToLower(key),
+			value: value,
+		})
+	}
+	return s
+}
+
+// unconditional reports whether the section applies everywhere
+func (s section) unconditional() bool {
+	return len(s.conditions) == 0
+}
+
+// matches reports whether all conditions of the section hold for h
+func (s section) matches(h Host) bool {
+	for _, c := range s.conditions {
+		if !c.matches(h) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches evaluates a condition; values may use shell glob syntax
+func (c condition) matches(h Host) bool {
+	switch c.key {
+	case "host":
+		short, _, _ := strings.Cut(h.Hostname, ".")
+		return globFold(c.value, h.Hostname) || globFold(c.value, short)
+	case "os":
+		return globFold(c.value, h.OS)
+	case "user":
+		ok, _ := path.Match(c.value, h.User)
+		return ok
+	}
+	return false
+}
+
+// globFold matches a glob case-insensitively
+func globFold(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
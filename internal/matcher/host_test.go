@@ -0,0 +1,152 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSectionHeaderDetection(t *testing.T) {
+	tests := []struct {
+		line   string
+		header bool
+	}{
+		{"[host:build-box]", true},
+		{"[os:darwin user:alice]", true},
+		{"[*]", true},
+		{"[Bb]uild", false},
+		{"[abc]", false},
+		{"*.log", false},
+	}
+
+	for _, tt := range tests {
+		if got := isSectionHeader(tt.line); got != tt.header {
+			t.Errorf("isSectionHeader(%q) = %v, expected %v", tt.line, got, tt.header)
+		}
+	}
+}
+
+func TestSectionAtEnd(t *testing.T) {
+	tests := []struct {
+		content string
+		header  string
+	}{
+		{"", ""},
+		{"*.log\n", ""},
+		{"*.log\n[os:plan9]\nbuild/\n", "[os:plan9]"},
+		{"[os:plan9]", "[os:plan9]"},
+		{"[host:a]\nx\n[*]\ny\n", ""},
+		{"[host:a]\n[Bb]uild\n", "[host:a]"},
+	}
+	for _, tt := range tests {
+		if got := SectionAtEnd(tt.content); got != tt.header {
+			t.Errorf("SectionAtEnd(%q) = %q, expected %q", tt.content, got, tt.header)
+		}
+	}
+}
+
+func TestUnconditionalLines(t *testing.T) {
+	content := "a/\n[host:other]\nb/\n  [*]  \nc/\n[os:plan9]\nd/\n"
+	got := UnconditionalLines(content)
+	if len(got) != 2 || got[0] != "a/" || got[1] != "c/" {
+		t.Errorf("Expected the lines outside sections, got %q", got)
+	}
+}
+
+func TestSectionMatches(t *testing.T) {
+	host := Host{Hostname: "build-box.example.com", OS: "linux", User: "alice"}
+
+	tests := []struct {
+		header  string
+		matches bool
+	}{
+		{"[*]", true},
+		{"[host:build-box]", true},
+		{"[host:build-box.example.com]", true},
+		{"[host:BUILD-*]", true},
+		{"[host:laptop]", false},
+		{"[os:linux]", true},
+		{"[os:darwin]", false},
+		{"[user:alice]", true},
+		{"[user:Alice]", false},
+		{"[os:linux user:alice]", true},
+		{"[os:linux user:bob]", false},
+		{"[arch:arm64]", false}, // Unknown keys never match
+	}
+
+	for _, tt := range tests {
+		if got := parseSection(tt.header).matches(host); got != tt.matches {
+			t.Errorf("%s: expected matches=%v, got %v", tt.header, tt.matches, got)
+		}
+	}
+}
+
+func TestMatcherSections(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	ignoreContent := `*.log
+
+[host:laptop-*]
+artifacts/
+
+[os:darwin]
+.DS_Store
+
+[*]
+*.tmp
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dropboxignore"), []byte(ignoreContent), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	os.MkdirAll(filepath.Join(tmpDir, "artifacts"), 0755)
+
+	laptop, err := NewMatcherWithConfig(Config{
+		Host: Host{Hostname: "laptop-alice", OS: "linux", User: "alice"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	server, err := NewMatcherWithConfig(Config{
+		Host: Host{Hostname: "build-box", OS: "linux", User: "ci"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		laptop bool
+		server bool
+	}{
+		{filepath.Join(tmpDir, "debug.log"), true, true},
+		{filepath.Join(tmpDir, "artifacts"), true, false},
+		{filepath.Join(tmpDir, ".DS_Store"), false, false},
+		{filepath.Join(tmpDir, "scratch.tmp"), true, true},
+	}
+
+	for _, tt := range tests {
+		if got, _ := laptop.ShouldIgnore(tt.path); got != tt.laptop {
+			t.Errorf("laptop: path %s: expected ignore=%v, got %v", tt.path, tt.laptop, got)
+		}
+		if got, _ := server.ShouldIgnore(tt.path); got != tt.server {
+			t.Errorf("server: path %s: expected ignore=%v, got %v", tt.path, tt.server, got)
+		}
+	}
+
+	// Explain reports the rule and the section it came from
+	res, err := laptop.Explain(filepath.Join(tmpDir, "artifacts"))
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if res.Rule == nil {
+		t.Fatal("Expected a matching rule")
+	}
+	if res.Rule.Line != 4 || res.Rule.Pattern != "artifacts/" || res.Rule.Section != "[host:laptop-*]" {
+		t.Errorf("Unexpected rule: %+v", *res.Rule)
+	}
+
+	res, _ = laptop.Explain(filepath.Join(tmpDir, "debug.log"))
+	if res.Rule == nil || res.Rule.Section != "" {
+		t.Errorf("Expected unconditional rule, got %+v", res.Rule)
+	}
+}
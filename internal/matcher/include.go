@@ -0,0 +1,108 @@
+package matcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// explainInclude evaluates the closest .dropboxinclude for a path. It
+// reports whether the whitelist decided the path: listed entries are kept,
+// entries excluded by a !pattern are ignored by that rule and everything
+// else is ignored by default. Directories that may hold listed entries are
+// left undecided so that their contents are still visited.
+func (m *Matcher) explainInclude(path string, t target) (Result, bool, error) {
+	includeFile := m.findRuleFile(filepath.Dir(path), includeFileName)
+	if includeFile == "" {
+		return Result{}, false, nil
+	}
+
+	// The rule files themselves always stay in Dropbox
+	if name := filepath.Base(path); name == includeFileName || name == ignoreFileName {
+		return Result{}, true, nil
+	}
+
+	include, err := m.getOrLoadIgnore(includeFile)
+	if err != nil {
+		return Result{}, false, err
+	}
+
+	base := filepath.Dir(includeFile)
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return Result{}, false, err
+	}
+	t.rel = filepath.ToSlash(rel)
+
+	if rule := decideInclude(t, base, include.lastMatch); rule != nil {
+		if rule.Negate {
+			return Result{Ignored: true, Rule: rule}, true, nil
+		}
+		return Result{}, true, nil
+	}
+	if t.typ.IsDir() && include.mayContain(t.rel) {
+		return Result{}, false, nil
+	}
+	return Result{Ignored: true, IncludeFile: includeFile}, true, nil
+}
+
+// decideInclude returns the whitelist rule that decides an entry. It
+// mirrors decide with the roles swapped: an excluded parent directory
+// cannot be listed again, while a listed directory lists its contents
+// unless a later !pattern excludes them.
+func decideInclude(t target, base string, lastMatch func(target) *Rule) *Rule {
+	var listed *Rule
+	for i := 0; i < len(t.rel); i++ {
+		if t.rel[i] != '/' {
+			continue
+		}
+		parent := target{rel: t.rel[:i], typ: fs.ModeDir, now: t.now}
+		parent.info = lazyStat(filepath.Join(base, filepath.FromSlash(parent.rel)))
+		rule := lastMatch(parent)
+		if rule == nil {
+			continue
+		}
+		if rule.Negate {
+			return rule
+		}
+		listed = rule
+	}
+	if rule := lastMatch(t); rule != nil {
+		return rule
+	}
+	return listed
+}
+
+// mayContain reports whether a rule could list an entry inside dir, a
+// slash-separated path relative to the rule file's directory
+func (f *IgnoreFile) mayContain(dir string) bool {
+	for i := range f.Rules {
+		if !f.Rules[i].Negate && f.Rules[i].pattern.mayMatchBelow(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchBelow reports whether the pattern could match an entry inside
+// dir. It errs on the side of true: name patterns and regular expressions
+// can match at any depth.
+func (p pattern) mayMatchBelow(dir string) bool {
+	if p.basename || p.re != nil {
+		return true
+	}
+	segments := strings.Split(p.glob, "/")
+	for i, name := range strings.Split(dir, "/") {
+		// The last segment can only match dir itself or one of its parents
+		if i >= len(segments)-1 {
+			return false
+		}
+		if segments[i] == "**" {
+			return true
+		}
+		if !wildmatch(segments[i], name, wmPathname) {
+			return false
+		}
+	}
+	return true
+}
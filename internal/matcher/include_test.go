@@ -0,0 +1,88 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherInclude(t *testing.T) {
+	tmpDir := t.TempDir()
+	downloads := filepath.Join(tmpDir, "Downloads")
+
+	files := map[string]string{
+		".dropboxignore":                  "*.log\n",
+		"Downloads/.dropboxinclude":       "*.pdf\nKeep/\n!Keep/*.tmp\nreceipts/2024/\n",
+		"Downloads/setup.exe":             "",
+		"Downloads/paper.pdf":             "",
+		"Downloads/debug.log":             "",
+		"Downloads/Keep/notes.txt":        "",
+		"Downloads/Keep/draft.tmp":        "",
+		"Downloads/misc/deep/manual.pdf":  "",
+		"Downloads/misc/deep/video.mp4":   "",
+		"Downloads/receipts/2024/may.txt": "",
+		"Downloads/receipts/2023/may.txt": "",
+		"Other/setup.exe":                 "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	m, err := NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+
+	tests := []struct {
+		path      string
+		ignore    bool
+		byDefault bool
+	}{
+		{"Downloads/.dropboxinclude", false, false},
+		{"Downloads/setup.exe", true, true},
+		{"Downloads/paper.pdf", false, false},
+		// Explicit .dropboxignore rules still apply
+		{"Downloads/debug.log", true, false},
+		{"Downloads/Keep", false, false},
+		{"Downloads/Keep/notes.txt", false, false},
+		{"Downloads/Keep/draft.tmp", true, false},
+		// Directories are kept while they may hold listed entries
+		{"Downloads/misc", false, false},
+		{"Downloads/misc/deep/manual.pdf", false, false},
+		{"Downloads/misc/deep/video.mp4", true, true},
+		{"Downloads/receipts", false, false},
+		{"Downloads/receipts/2024/may.txt", false, false},
+		// *.pdf can match at any depth, so every directory stays
+		{"Downloads/receipts/2023", false, false},
+		{"Downloads/receipts/2023/may.txt", true, true},
+		// Outside the whitelisted directory nothing changes
+		{"Other/setup.exe", false, false},
+	}
+
+	for _, tt := range tests {
+		res, err := m.Explain(filepath.Join(tmpDir, tt.path))
+		if err != nil {
+			t.Errorf("Explain(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if res.Ignored != tt.ignore {
+			t.Errorf("Explain(%s): expected ignored=%v, got %v", tt.path, tt.ignore, res.Ignored)
+		}
+		if byDefault := res.IncludeFile != ""; byDefault != tt.byDefault {
+			t.Errorf("Explain(%s): expected by default=%v, got %v", tt.path, tt.byDefault, byDefault)
+		}
+		if res.Ignored && !tt.byDefault && res.Rule == nil {
+			t.Errorf("Explain(%s): expected an explicit rule", tt.path)
+		}
+	}
+
+	if res, _ := m.Explain(filepath.Join(downloads, "setup.exe")); res.IncludeFile != filepath.Join(downloads, ".dropboxinclude") {
+		t.Errorf("Expected include file to be reported, got %q", res.IncludeFile)
+	}
+}
@@ -0,0 +1,282 @@
+package matcher
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Severity tells errors, which break rules or expectations, from warnings
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+// Diagnostic is a problem found in an ignore file
+type Diagnostic struct {
+	File     string
+	Line     int
+	Message  string
+	Severity Severity
+}
+
+// String formats the diagnostic as file:line: message
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// ruleStats records how a rule fared against the entries its file governs
+type ruleStats struct {
+	matched int
+	// byParent counts entries decided by a rule on a parent directory
+	byParent int
+	parent   *Rule
+	// shadowed counts entries whose previous matching rule already had
+	// the same effect
+	shadowed int
+	shadow   *Rule
+	// overridden counts entries decided by a later rule
+	overridden int
+	override   *Rule
+}
+
+// Lint checks every .dropboxignore and .dropboxinclude file under root. It
+// reports lines that do not compile and duplicate lines in every section,
+// and checks the rules that apply to this host against the current tree:
+// rules that match nothing, rules shadowed by earlier ones, and rules that
+// never take effect because a parent directory is already decided, such as
+// a negation below an excluded directory. Failing expectations are
+// reported as well; see Test.
+func (m *Matcher) Lint(root string) ([]Diagnostic, error) {
+	files, err := m.FindRuleFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []Diagnostic
+	for _, file := range files {
+		fileDiags, err := m.LintFile(file)
+		if err != nil {
+			return nil, err
+		}
+		diags = append(diags, fileDiags...)
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].File != diags[j].File {
+			return diags[i].File < diags[j].File
+		}
+		return diags[i].Line < diags[j].Line
+	})
+	return diags, nil
+}
+
+// FindRuleFiles returns every .dropboxignore and .dropboxinclude file
+// under root. Directories that cannot be read are logged and skipped.
+func (m *Matcher) FindRuleFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return m.skipWalkError(root, path, d, err)
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (d.Name() == ignoreFileName || d.Name() == includeFileName) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// LintFile checks a single rule file like Lint
+func (m *Matcher) LintFile(path string) ([]Diagnostic, error) {
+	all, err := m.loadRules(path, &m.host, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []Diagnostic
+	// report adds a warning
+	report := func(line int, format string, args ...any) {
+		diags = append(diags, Diagnostic{File: path, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, perr := range all.Errors {
+		diags = append(diags, Diagnostic{File: path, Line: perr.Line, Message: perr.Err.Error(), Severity: SeverityError})
+	}
+
+	// A line repeats another when both apply on the same hosts, or the
+	// earlier one applies everywhere
+	seen := make(map[string]int)
+	duplicate := make(map[int]bool)
+	for _, rule := range all.Rules {
+		line, ok := seen[rule.Section+"\x00"+rule.Pattern]
+		if !ok {
+			line, ok = seen["\x00"+rule.Pattern]
+		}
+		if ok {
+			report(rule.Line, "duplicate of line %d", line)
+			duplicate[rule.Line] = true
+			continue
+		}
+		seen[rule.Section+"\x00"+rule.Pattern] = rule.Line
+	}
+
+	// The remaining checks only look at the rules that apply here, without
+	// the duplicates already reported
+	f := &IgnoreFile{Path: path}
+	for _, rule := range all.Rules {
+		if duplicate[rule.Line] {
+			continue
+		}
+		if rule.Section == "" || parseSection(rule.Section).matches(m.host) {
+			f.Rules = append(f.Rules, rule)
+		}
+	}
+	stats, err := m.collectRuleStats(f)
+	if err != nil {
+		return nil, err
+	}
+
+	include := filepath.Base(path) == includeFileName
+	for i, rule := range f.Rules {
+		s := stats[i]
+		switch {
+		case s.matched == 0:
+			report(rule.Line, "pattern %q matches nothing in the current tree", rule.Pattern)
+		case s.byParent == s.matched && rule.Negate && !include:
+			report(rule.Line, "negation can never re-include a path: parent directory is excluded by line %d", s.parent.Line)
+		case s.byParent == s.matched:
+			report(rule.Line, "rule has no effect: parent directory is decided by line %d", s.parent.Line)
+		case s.byParent+s.shadowed+s.overridden < s.matched:
+			// The rule decides at least one entry
+		case s.shadowed > 0:
+			report(rule.Line, "rule is shadowed by line %d", s.shadow.Line)
+		case s.overridden > 0:
+			report(rule.Line, "rule is always overridden by line %d", s.override.Line)
+		}
+	}
+
+	_, testDiags, err := m.Test(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(diags, testDiags...), nil
+}
+
+// RuleMatches counts the entries in the current tree that each rule of a
+// file matches, keyed by line. Only rules that apply to this host are
+// counted.
+func (m *Matcher) RuleMatches(path string) (map[int]int, error) {
+	f, err := m.loadRules(path, &m.host, false)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := m.collectRuleStats(f)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int, len(f.Rules))
+	for i, rule := range f.Rules {
+		counts[rule.Line] = stats[i].matched
+	}
+	return counts, nil
+}
+
+// collectRuleStats evaluates a file's rules against every entry it governs, that
+// is every entry below its directory that has no closer rule file of the
+// same name. Directories that cannot be read are logged and skipped.
+func (m *Matcher) collectRuleStats(f *IgnoreFile) ([]ruleStats, error) {
+	base := filepath.Dir(f.Path)
+	name := filepath.Base(f.Path)
+	decideFn := decide
+	if name == includeFileName {
+		decideFn = decideInclude
+	}
+
+	stats := make([]ruleStats, len(f.Rules))
+	now := time.Now()
+
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return m.skipWalkError(base, path, d, err)
+		}
+		if path == base {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		t := target{rel: filepath.ToSlash(rel), typ: d.Type(), info: sync.OnceValues(d.Info), now: now}
+
+		var matching []int
+		for i := range f.Rules {
+			if f.Rules[i].pattern.matches(t) {
+				matching = append(matching, i)
+			}
+		}
+		if len(matching) > 0 {
+			own := &f.Rules[matching[len(matching)-1]]
+			decider := decideFn(t, base, f.lastMatch)
+			for n, i := range matching {
+				s := &stats[i]
+				s.matched++
+				if decider != nil && decider != own {
+					s.byParent++
+					s.parent = decider
+					continue
+				}
+				if &f.Rules[i] != own {
+					s.overridden++
+					if s.override == nil {
+						s.override = own
+					}
+					continue
+				}
+				if n > 0 {
+					prev := &f.Rules[matching[n-1]]
+					if prev.Negate == f.Rules[i].Negate {
+						s.shadowed++
+						if s.shadow == nil {
+							s.shadow = prev
+						}
+					}
+				}
+			}
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			// Entries below a closer rule file are governed by that file
+			if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// skipWalkError handles an error passed to a WalkDir callback like the
+// poller does: it is logged and the entry skipped, so that one unreadable
+// directory does not abort the walk. Only an unreadable root is an error.
+func (m *Matcher) skipWalkError(root, path string, d fs.DirEntry, err error) error {
+	if path == root {
+		return err
+	}
+	m.logger.Warn("Walk error", "path", path, "error", err)
+	if d != nil && d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
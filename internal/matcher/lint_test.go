@@ -0,0 +1,135 @@
+package matcher
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		".dropboxignore": "*.log\n" + // 1
+			"build/\n" + // 2
+			"!build/keep.txt\n" + // 3
+			"*.log\n" + // 4
+			"debug.log\n" + // 5
+			"*.tmp\n" + // 6
+			"re:(unclosed\n" + // 7
+			"[os:plan9]\n" + // 8
+			"*.log\n" + // 9
+			"[*]\n" + // 10
+			"cache/\n" + // 11
+			"!cache/\n", // 12
+		"debug.log":          "",
+		"app.log":            "",
+		"build/keep.txt":     "",
+		"build/out.bin":      "",
+		"cache/data":         "",
+		"sub/.dropboxignore": "*.log\n",
+		// Governed by sub/.dropboxignore, so *.tmp above still matches nothing
+		"sub/x.tmp": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	m, err := NewMatcherWithConfig(Config{Host: Host{OS: "linux"}})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	diags, err := m.Lint(tmpDir)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+
+	root := filepath.Join(tmpDir, ".dropboxignore")
+	sub := filepath.Join(tmpDir, "sub", ".dropboxignore")
+	expected := []Diagnostic{
+		{root, 3, "negation can never re-include a path: parent directory is excluded by line 2", SeverityWarning},
+		{root, 4, "duplicate of line 1", SeverityWarning},
+		{root, 5, "rule is shadowed by line 1", SeverityWarning},
+		{root, 6, `pattern "*.tmp" matches nothing in the current tree`, SeverityWarning},
+		{root, 7, "invalid regular expression: error parsing regexp: missing closing ): `(unclosed`", SeverityError},
+		{root, 9, "duplicate of line 1", SeverityWarning},
+		{root, 11, "rule is always overridden by line 12", SeverityWarning},
+		{sub, 1, `pattern "*.log" matches nothing in the current tree`, SeverityWarning},
+	}
+
+	if len(diags) != len(expected) {
+		for _, d := range diags {
+			t.Log(d)
+		}
+		t.Fatalf("Expected %d diagnostics, got %d", len(expected), len(diags))
+	}
+	for i, d := range diags {
+		if d != expected[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, expected[i], d)
+		}
+	}
+}
+
+func TestLintSkipsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	tmpDir := t.TempDir()
+	for name, content := range map[string]string{
+		".dropboxignore":        "*.log\n",
+		"app.log":               "",
+		"locked/.dropboxignore": "*.tmp\n",
+		"locked/x.tmp":          "",
+	} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	locked := filepath.Join(tmpDir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("Failed to lock directory: %v", err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	m, err := NewMatcherWithConfig(Config{Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	files, err := m.FindRuleFiles(tmpDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected the readable ignore file only, got %v, %v", files, err)
+	}
+	diags, err := m.Lint(tmpDir)
+	if err != nil || len(diags) != 0 {
+		t.Errorf("Expected a clean lint past the unreadable directory, got %v, %v", diags, err)
+	}
+}
+
+func TestSkipWalkError(t *testing.T) {
+	m, err := NewMatcherWithConfig(Config{Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	dir := VirtualEntry("sub/")
+	if err := m.skipWalkError("/root", "/root/sub", dir, fs.ErrPermission); err != filepath.SkipDir {
+		t.Errorf("Expected an unreadable directory to be skipped, got %v", err)
+	}
+	if err := m.skipWalkError("/root", "/root/file", nil, fs.ErrNotExist); err != nil {
+		t.Errorf("Expected a vanished entry to be ignored, got %v", err)
+	}
+	if err := m.skipWalkError("/root", "/root", dir, fs.ErrPermission); err != fs.ErrPermission {
+		t.Errorf("Expected an unreadable root to fail, got %v", err)
+	}
+}
@@ -1,16 +1,35 @@
 // Package matcher provides glob pattern matching for .dropboxignore files
-// with LRU caching for compiled patterns.
+// with LRU caching for compiled patterns. Patterns follow git's gitignore
+// semantics exactly and are evaluated by a port of git's wildmatch.
+//
+// Rules can be limited to some machines with section headers such as
+// [host:build-box], [os:darwin] or [user:alice]; [*] ends the section.
+// A rule may start with a qualifier group such as (file), (dir), (link),
+// an extension class like (video) or metadata predicates like
+// (size>2G age>90d mtime<2024-01-01), and re: introduces an RE2 regular
+// expression instead of a glob.
+//
+// A .dropboxinclude file turns its directory into a whitelist: everything
+// below it is ignored unless one of its rules lists it, and !pattern rules
+// exclude again. Explicit .dropboxignore rules are applied first.
+//
+// Rule files can carry "# expect-ignored: path" and "# expect-synced: path"
+// assertions, or keep them in a companion .test file; Test checks them.
 package matcher
 
 import (
-	"bufio"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
-	gitignore "github.com/sabhiram/go-gitignore"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/symlink"
 )
 
 const (
@@ -18,94 +37,204 @@ const (
 	defaultCacheSize = 32
 	// Name of the ignore file
 	ignoreFileName = ".dropboxignore"
+	// Name of the whitelist file
+	includeFileName = ".dropboxinclude"
 )
 
 // Matcher manages ignore patterns from .dropboxignore files
 type Matcher struct {
 	mu    sync.RWMutex
-	cache *lru.Cache[string, *gitignore.GitIgnore]
+	cache  *lru.Cache[string, *IgnoreFile]
+	host   Host
+	logger *slog.Logger
+	
+	// cacheHits and cacheMisses count rule file lookups
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	
+	// stats counts what each rule ignored, if countStats is set
+	countStats bool
+	statsMu    sync.Mutex
+	stats      map[ruleKey]*ruleCounts
+	
+	// overlays replace rule files with unsaved editor content
+	overlayMu sync.RWMutex
+	overlays  map[string][]byte
+	
+	// symlinks decides how ShouldIgnore and Explain treat links
+	symlinks symlink.Resolver
+	
+	// predicates is set once a rule file with metadata predicates is loaded
+	predicates atomic.Bool
+	
+	// gitIgnore enables .gitignore rules inside git work trees
+	gitIgnore bool
+	gitMu     sync.Mutex
+	indexes   map[string]*cachedIndex
+}
+
+// Config holds matcher configuration
+type Config struct {
+	CacheSize int
+	// Host is used to evaluate [host:], [os:] and [user:] sections.
+	// Defaults to CurrentHost().
+	Host Host
+	// GitIgnore also applies .gitignore, .git/info/exclude and
+	// core.excludesFile rules to paths inside git work trees.
+	// Tracked files are never ignored.
+	GitIgnore bool
+	// Logger receives warnings about rules that fail to compile
+	Logger *slog.Logger
+	// Symlinks decides how ShouldIgnore and Explain treat links: matched
+	// as their target, as themselves, or never ignored
+	Symlinks symlink.Resolver
+	// Stats counts what each rule ignores for Stats. Only one-off scans
+	// such as the stats command report it.
+	Stats bool
+}
+
+// Result describes how a path was matched
+type Result struct {
+	Ignored bool
+	// Rule is the rule that ignored the path, nil when not ignored or
+	// ignored by default
+	Rule *Rule
+	// IncludeFile is set when the path was ignored by default because the
+	// closest .dropboxinclude does not list it
+	IncludeFile string
+}
+
+// Describe names what ignored the path: the rule, or the .dropboxinclude
+// that does not list it
+func (res Result) Describe() string {
+	if res.Rule == nil {
+		return "not listed in " + res.IncludeFile
+	}
+	return res.Rule.Describe()
 }
 
 // NewMatcher creates a new pattern matcher with specified cache size
 func NewMatcher(cacheSize int) (*Matcher, error) {
-	if cacheSize <= 0 {
-		cacheSize = defaultCacheSize
+	return NewMatcherWithConfig(Config{CacheSize: cacheSize})
+}
+
+// NewMatcherWithConfig creates a new pattern matcher from a Config
+func NewMatcherWithConfig(cfg Config) (*Matcher, error) {
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	if cfg.Host == (Host{}) {
+		cfg.Host = CurrentHost()
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
 	}
 	
-	cache, err := lru.New[string, *gitignore.GitIgnore](cacheSize)
+	cache, err := lru.New[string, *IgnoreFile](cfg.CacheSize)
 	if err != nil {
 		return nil, err
 	}
 	
 	return &Matcher{
-		cache: cache,
+		cache:      cache,
+		host:       cfg.Host,
+		logger:     cfg.Logger,
+		countStats: cfg.Stats,
+		stats:      make(map[ruleKey]*ruleCounts),
+		overlays:   make(map[string][]byte),
+		symlinks:   cfg.Symlinks,
+		gitIgnore:  cfg.GitIgnore,
+		indexes:    make(map[string]*cachedIndex),
 	}, nil
 }
 
 // ShouldIgnore checks if a path should be ignored based on .dropboxignore patterns
 func (m *Matcher) ShouldIgnore(path string) (bool, error) {
-	// Find the closest .dropboxignore file
-	dir := filepath.Dir(path)
-	ignoreFile := m.findIgnoreFile(dir)
-	if ignoreFile == "" {
-		return false, nil
-	}
-	
-	// Get or load the ignore patterns
-	ignore, err := m.getOrLoadIgnore(ignoreFile)
-	if err != nil {
-		return false, err
-	}
-	
-	// Check if path matches any pattern
-	relPath, err := filepath.Rel(filepath.Dir(ignoreFile), path)
-	if err != nil {
-		return false, err
+	res, err := m.Explain(path)
+	return res.Ignored, err
+}
+
+// Explain checks a path like ShouldIgnore and also reports the rule that matched
+func (m *Matcher) Explain(path string) (Result, error) {
+	var d fs.DirEntry
+	stat, err := m.symlinks.Stat(path)
+	switch {
+	case errors.Is(err, symlink.ErrSkipped) || errors.Is(err, symlink.ErrEscapesRoot):
+		// Links the policy leaves alone are never ignored
+		return Result{}, nil
+	case err == nil:
+		d = fs.FileInfoToDirEntry(stat)
 	}
-	
-	// For directories, also check with trailing slash
-	matches := ignore.MatchesPath(relPath)
-	if !matches {
-		// Check if it's a directory
-		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
-			// Try matching with trailing slash
-			matches = ignore.MatchesPath(relPath + "/")
-		}
+	return m.Match(path, d)
+}
+
+// Match checks a path whose directory entry is already known, for example
+// from a directory listing. The entry's metadata is only read when a rule
+// with size, age or mtime predicates needs it. d may be nil when the path
+// does not exist.
+func (m *Matcher) Match(path string, d fs.DirEntry) (Result, error) {
+	res, err := m.match(path, d)
+	if err == nil && res.Ignored && m.countStats {
+		m.countMatch(res)
 	}
-	
-	return matches, nil
+	return res, err
 }
 
-// LoadIgnoreFile loads patterns from a .dropboxignore file
-func (m *Matcher) LoadIgnoreFile(path string) (*gitignore.GitIgnore, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func (m *Matcher) match(path string, d fs.DirEntry) (Result, error) {
+	t := target{now: time.Now()}
+	if d != nil {
+		t.typ = d.Type()
+		t.info = sync.OnceValues(d.Info)
 	}
-	defer file.Close()
-	
-	var patterns []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comments
-		if line != "" && !strings.HasPrefix(line, "#") {
-			patterns = append(patterns, line)
+
+	// Find the closest .dropboxignore file
+	dir := filepath.Dir(path)
+	ignoreFile := m.findRuleFile(dir, ignoreFileName)
+	if ignoreFile != "" {
+		// Get or load the ignore patterns
+		ignore, err := m.getOrLoadIgnore(ignoreFile)
+		if err != nil {
+			return Result{}, err
+		}
+		
+		// Check if path matches any pattern
+		relPath, err := filepath.Rel(filepath.Dir(ignoreFile), path)
+		if err != nil {
+			return Result{}, err
+		}
+		
+		t.rel = filepath.ToSlash(relPath)
+		if rule := decide(t, filepath.Dir(ignoreFile), ignore.lastMatch); rule != nil {
+			// An explicit !pattern also keeps git rules from applying
+			if rule.Negate {
+				return Result{}, nil
+			}
+			return Result{Ignored: true, Rule: rule}, nil
 		}
 	}
 	
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if res, decided, err := m.explainInclude(path, t); err != nil || decided {
+		return res, err
 	}
 	
-	return gitignore.CompileIgnoreLines(patterns...), nil
+	if m.gitIgnore {
+		return m.explainGit(path, t)
+	}
+	return Result{}, nil
+}
+
+// LoadIgnoreFile loads patterns from a .dropboxignore file, keeping only
+// the sections that apply to the matcher's host
+func (m *Matcher) LoadIgnoreFile(path string) (*IgnoreFile, error) {
+	return m.loadRules(path, &m.host, false)
 }
 
 // getOrLoadIgnore retrieves patterns from cache or loads from file
-func (m *Matcher) getOrLoadIgnore(path string) (*gitignore.GitIgnore, error) {
+func (m *Matcher) getOrLoadIgnore(path string) (*IgnoreFile, error) {
 	m.mu.RLock()
 	if ignore, ok := m.cache.Get(path); ok {
 		m.mu.RUnlock()
+		m.cacheHits.Add(1)
 		return ignore, nil
 	}
 	m.mu.RUnlock()
@@ -116,23 +245,63 @@ func (m *Matcher) getOrLoadIgnore(path string) (*gitignore.GitIgnore, error) {
 	
 	// Double-check after acquiring write lock
 	if ignore, ok := m.cache.Get(path); ok {
+		m.cacheHits.Add(1)
 		return ignore, nil
 	}
+	m.cacheMisses.Add(1)
 	
-	ignore, err := m.LoadIgnoreFile(path)
+	var host *Host
+	if name := filepath.Base(path); name == ignoreFileName || name == includeFileName {
+		host = &m.host
+	}
+	ignore, err := m.loadRules(path, host, false)
 	if err != nil {
 		return nil, err
 	}
+	for _, perr := range ignore.Errors {
+		m.logger.Warn("Skipping invalid rule", "error", perr)
+	}
 	
 	m.cache.Add(path, ignore)
 	return ignore, nil
 }
 
-// findIgnoreFile searches for the closest .dropboxignore file
-func (m *Matcher) findIgnoreFile(dir string) string {
+// UsesPredicates reports whether any rule file loaded so far has a rule
+// with size, age or mtime predicates, whose outcome can change when a file
+// is written in place. It stays set once such a file was loaded.
+func (m *Matcher) UsesPredicates() bool {
+	return m.predicates.Load()
+}
+
+// findRuleFile searches for the closest rule file with the given name
+func (m *Matcher) findRuleFile(dir, name string) string {
+	return findFile(dir, name, m.fileExists)
+}
+
+// FindIgnoreFile returns the closest .dropboxignore at or above dir, which
+// is the one whose rules apply to dir's entries, or "" when there is none
+func FindIgnoreFile(dir string) string {
+	return findFile(dir, ignoreFileName, func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+}
+
+// HiddenRules returns the .dropboxignore whose rules would stop applying
+// below file's directory if file were created, as only the closest one
+// applies, or "" when file exists or no other one is above it
+func HiddenRules(file string) string {
+	if _, err := os.Stat(file); err == nil {
+		return ""
+	}
+	return FindIgnoreFile(filepath.Dir(filepath.Dir(file)))
+}
+
+// findFile searches dir and its parents for a file with the given name
+func findFile(dir, name string, exists func(path string) bool) string {
 	for {
-		ignoreFile := filepath.Join(dir, ignoreFileName)
-		if _, err := os.Stat(ignoreFile); err == nil {
+		ignoreFile := filepath.Join(dir, name)
+		if exists(ignoreFile) {
 			return ignoreFile
 		}
 		
@@ -146,11 +315,21 @@ func (m *Matcher) findIgnoreFile(dir string) string {
 	return ""
 }
 
+// CacheStats returns how often rule files were found in the cache and
+// how often they had to be loaded
+func (m *Matcher) CacheStats() (hits, misses uint64) {
+	return m.cacheHits.Load(), m.cacheMisses.Load()
+}
+
 // ClearCache removes all cached patterns
 func (m *Matcher) ClearCache() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.cache.Purge()
+	m.mu.Unlock()
+	
+	m.gitMu.Lock()
+	m.indexes = make(map[string]*cachedIndex)
+	m.gitMu.Unlock()
 }
 
 // InvalidatePath removes cached patterns for a specific ignore file
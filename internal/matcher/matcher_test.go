@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/symlink"
 )
 
 func TestMatcherBasic(t *testing.T) {
@@ -135,6 +137,11 @@ func TestMatcherCache(t *testing.T) {
 		t.Error("Expected test.log to be ignored (cached)")
 	}
 	
+	hits, misses := m.CacheStats()
+	if hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d and %d", hits, misses)
+	}
+	
 	// Clear cache and check again
 	m.ClearCache()
 	shouldIgnore3, _ := m.ShouldIgnore(testFile)
@@ -162,6 +169,46 @@ func TestMatcherNoIgnoreFile(t *testing.T) {
 	}
 }
 
+func TestMatcherSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dropboxignore"), []byte("build/\nlink*\n"), 0644); err != nil {
+		t.Fatalf("Failed to create .dropboxignore: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "out"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	// build is a link to a directory, matched by build/ only when followed
+	if err := os.Symlink("out", filepath.Join(tmpDir, "build")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(tmpDir, "linkout")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	
+	tests := []struct {
+		policy  symlink.Policy
+		build   bool
+		linkout bool
+	}{
+		{symlink.Follow, true, false},
+		{symlink.Mark, false, true},
+		{symlink.Skip, false, false},
+	}
+	for _, tt := range tests {
+		m, err := NewMatcherWithConfig(Config{Symlinks: symlink.Resolver{Policy: tt.policy, Root: tmpDir}})
+		if err != nil {
+			t.Fatalf("Failed to create matcher: %v", err)
+		}
+		if got, err := m.ShouldIgnore(filepath.Join(tmpDir, "build")); err != nil || got != tt.build {
+			t.Errorf("%s: ShouldIgnore(build) = %v, %v; expected %v", tt.policy, got, err, tt.build)
+		}
+		if got, err := m.ShouldIgnore(filepath.Join(tmpDir, "linkout")); err != nil || got != tt.linkout {
+			t.Errorf("%s: ShouldIgnore(linkout) = %v, %v; expected %v", tt.policy, got, err, tt.linkout)
+		}
+	}
+}
+
 func TestLoadIgnoreFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	
@@ -208,4 +255,27 @@ relative/path
 			t.Errorf("Path %s: expected ignore=%v", tt.path, tt.ignore)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestMatcherUsesPredicates(t *testing.T) {
+	tmpDir := t.TempDir()
+	plain := filepath.Join(tmpDir, "plain")
+	sized := filepath.Join(tmpDir, "sized")
+	os.MkdirAll(plain, 0755)
+	os.MkdirAll(sized, 0755)
+	os.WriteFile(filepath.Join(plain, ".dropboxignore"), []byte("*.log\n"), 0644)
+	os.WriteFile(filepath.Join(sized, ".dropboxignore"), []byte("(size>1M) *.bin\n"), 0644)
+	
+	m, err := NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	m.ShouldIgnore(filepath.Join(plain, "a.log"))
+	if m.UsesPredicates() {
+		t.Error("Expected no predicates after loading plain rules")
+	}
+	m.ShouldIgnore(filepath.Join(sized, "a.bin"))
+	if !m.UsesPredicates() {
+		t.Error("Expected predicates after loading a size rule")
+	}
+}
@@ -0,0 +1,65 @@
+package matcher
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Overlay makes the matcher read content instead of the rule file at path,
+// so that editors can evaluate unsaved buffers. The file need not exist on
+// disk. A nil content removes the overlay again.
+func (m *Matcher) Overlay(path string, content []byte) {
+	m.overlayMu.Lock()
+	if content == nil {
+		delete(m.overlays, path)
+	} else {
+		m.overlays[path] = content
+	}
+	m.overlayMu.Unlock()
+
+	m.InvalidatePath(path)
+}
+
+// openFile opens a rule file, preferring an overlay
+func (m *Matcher) openFile(path string) (io.ReadCloser, error) {
+	m.overlayMu.RLock()
+	content, ok := m.overlays[path]
+	m.overlayMu.RUnlock()
+	if ok {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	return os.Open(path)
+}
+
+// fileExists reports whether a rule file exists on disk or as an overlay
+func (m *Matcher) fileExists(path string) bool {
+	m.overlayMu.RLock()
+	_, ok := m.overlays[path]
+	m.overlayMu.RUnlock()
+	if ok {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadRules parses a rule file; see readRules
+func (m *Matcher) loadRules(path string, host *Host, allSections bool) (*IgnoreFile, error) {
+	file, err := m.openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := readRules(file, path, host, allSections)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range f.Rules {
+		if len(rule.pattern.preds) > 0 {
+			m.predicates.Store(true)
+			break
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,262 @@
+package matcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix marks a pattern as an RE2 regular expression
+const regexPrefix = "re:"
+
+// entryKind is a set of entry types a pattern is limited to
+type entryKind uint8
+
+const (
+	kindFile entryKind = 1 << iota
+	kindDir
+	kindLink
+)
+
+// qualifiers maps the words accepted in a (...) group to entry kinds
+var qualifiers = map[string]entryKind{
+	"file": kindFile,
+	"dir":  kindDir,
+	"link": kindLink,
+}
+
+// pattern is a compiled gitignore-style pattern, optionally extended with
+// a regular expression, entry type qualifiers and metadata predicates
+type pattern struct {
+	// glob is the wildmatch expression with negation, anchoring slash and
+	// directory marker removed
+	glob string
+	// re replaces glob for re: patterns
+	re *regexp.Regexp
+	// negate is set for patterns starting with '!'
+	negate bool
+	// dirOnly is set for patterns ending in '/'
+	dirOnly bool
+	// basename is set when the pattern has no slash and is therefore
+	// matched against the last path component at any depth
+	basename bool
+	// kinds limits the entry types the pattern applies to; zero means all
+	kinds entryKind
+	// exts limits the pattern to extension classes such as video
+	exts map[string]bool
+	// preds are metadata predicates that must all hold
+	preds []predicate
+}
+
+// parsePattern compiles a single line using git's rules plus the
+// extensions understood in .dropboxignore files:
+//
+//	(file) *.tmp                 type qualifiers: file, dir and link
+//	(video size>500M) Scratch/** extension classes and size, age and mtime predicates
+//	re:\.tmp\.[0-9]+$            RE2 regular expressions
+//
+// It reports false for blank lines and comments.
+func parsePattern(line string) (pattern, bool, error) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return pattern{}, false, nil
+	}
+	line = trimTrailingSpaces(line)
+	if line == "" {
+		return pattern{}, false, nil
+	}
+
+	var p pattern
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+
+	line, err := parseQualifiers(line, &p)
+	if err != nil {
+		return pattern{}, false, err
+	}
+
+	if expr, ok := strings.CutPrefix(line, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return pattern{}, false, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		p.re = re
+		// Like a glob, a regex without a slash applies to the entry name
+		p.basename = !strings.Contains(expr, "/")
+		return p, true, nil
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false, nil
+	}
+
+	if !strings.Contains(line, "/") {
+		p.basename = true
+	}
+	// A leading slash only anchors the pattern to the ignore file's directory
+	p.glob = strings.TrimPrefix(line, "/")
+	return p, true, nil
+}
+
+// parseQualifiers strips a leading group such as "(dir)", "(file link)" or
+// "(video size>500M age>30d)". A group made only of unknown words is left
+// alone so that names which happen to start with a parenthesis keep
+// working as plain globs.
+func parseQualifiers(line string, p *pattern) (string, error) {
+	if !strings.HasPrefix(line, "(") {
+		return line, nil
+	}
+	end := strings.IndexByte(line, ')')
+	if end < 0 {
+		return line, nil
+	}
+
+	var (
+		q       pattern
+		unknown []string
+		errs    []error
+	)
+	words := strings.FieldsFunc(line[1:end], func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, word := range words {
+		if kind, ok := qualifiers[word]; ok {
+			q.kinds |= kind
+			continue
+		}
+		if exts, ok := extensionClasses[word]; ok {
+			if q.exts == nil {
+				q.exts = make(map[string]bool)
+			}
+			for _, ext := range exts {
+				q.exts[ext] = true
+			}
+			continue
+		}
+		pred, ok, err := parsePredicate(word)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			q.preds = append(q.preds, pred)
+			continue
+		}
+		unknown = append(unknown, word)
+	}
+
+	known := q.kinds != 0 || q.exts != nil || len(q.preds) > 0
+	if !known && len(errs) == 0 {
+		return line, nil
+	}
+	if len(errs) > 0 {
+		return "", errs[0]
+	}
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown qualifier %q", unknown[0])
+	}
+
+	rest := strings.TrimLeft(line[end+1:], " ")
+	if rest == "" {
+		return "", fmt.Errorf("qualifier group without a pattern")
+	}
+	p.kinds, p.exts, p.preds = q.kinds, q.exts, q.preds
+	return rest, nil
+}
+
+// trimTrailingSpaces removes unescaped trailing spaces; "foo\ " keeps its
+// final space because the backslash escapes it
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		// Count the backslashes in front of the space
+		n := 0
+		for i := end - 2; i >= 0 && line[i] == '\\'; i-- {
+			n++
+		}
+		if n%2 == 1 {
+			break
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// EscapeGlob escapes the characters of a name with a special meaning in
+// patterns, including a trailing space that would otherwise be trimmed,
+// so that the pattern matches the name literally
+func EscapeGlob(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if strings.ContainsRune(`*?[\`, r) || (r == ' ' && i == len(s)-1) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// kindOf classifies an entry type
+func kindOf(typ fs.FileMode) entryKind {
+	switch {
+	case typ.IsDir():
+		return kindDir
+	case typ&fs.ModeSymlink != 0:
+		return kindLink
+	case typ.IsRegular():
+		return kindFile
+	}
+	return 0
+}
+
+// matches reports whether the pattern matches an entry, ignoring negation
+// and without considering parent directories. Metadata is only read when
+// the pattern has predicates; without it such patterns never match.
+func (p pattern) matches(t target) bool {
+	if p.kinds != 0 && p.kinds&kindOf(t.typ) == 0 {
+		return false
+	}
+	if p.dirOnly && !t.typ.IsDir() {
+		return false
+	}
+
+	subject := t.rel
+	if p.basename {
+		subject = path.Base(t.rel)
+	}
+	if p.exts != nil && !hasExtension(t.rel, p.exts) {
+		return false
+	}
+	if p.re != nil {
+		if !p.re.MatchString(subject) {
+			return false
+		}
+	} else if !wildmatch(p.glob, subject, wmPathname) {
+		return false
+	}
+
+	if len(p.preds) == 0 {
+		return true
+	}
+	if t.info == nil {
+		return false
+	}
+	info, err := t.info()
+	if err != nil {
+		return false
+	}
+	for _, pred := range p.preds {
+		if !pred(info, t.now) {
+			return false
+		}
+	}
+	return true
+}
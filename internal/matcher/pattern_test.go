@@ -0,0 +1,351 @@
+package matcher
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ignoreCases are gitignore-level cases; a trailing slash on a path marks
+// a directory. Each case was checked against git check-ignore.
+var ignoreCases = []struct {
+	patterns string
+	path     string
+	ignore   bool
+}{
+	// Comments, escapes and trailing spaces
+	{"#foo", "#foo", false},
+	{"\\#foo", "#foo", true},
+	{"\\!foo", "!foo", true},
+	{"foo   ", "foo", true},
+	{"foo\\ ", "foo ", true},
+	{"foo\\ ", "foo", false},
+	{"foo\r", "foo", true},
+
+	// Basename and anchored patterns
+	{"*.log", "a/b/debug.log", true},
+	{"/*.log", "debug.log", true},
+	{"/*.log", "a/debug.log", false},
+	{"doc/*.txt", "doc/notes.txt", true},
+	{"doc/*.txt", "doc/server/arch.txt", false},
+	{"doc/*.txt", "a/doc/notes.txt", false},
+	{"a/b", "x/a/b", false},
+
+	// Directory-only patterns
+	{"build/", "build/", true},
+	{"build/", "build", false},
+	{"build/", "src/build/", true},
+	{"build/", "build/out.o", true},
+	{"build", "build", true},
+
+	// Double asterisks
+	{"**/foo", "foo", true},
+	{"**/foo", "a/b/foo", true},
+	{"**/foo/bar", "x/foo/bar", true},
+	{"abc/**", "abc/x/y", true},
+	{"abc/**", "abc", false},
+	{"a/**/b", "a/b", true},
+	{"a/**/b", "a/x/y/b", true},
+	{"foo**bar", "foo/x/bar", false},
+
+	// Negation and parent directories
+	{"*.log\n!keep.log", "keep.log", false},
+	{"!keep.log\n*.log", "keep.log", true},
+	{"build/\n!build/keep.txt", "build/keep.txt", true},
+	{"build/*\n!build/keep.txt", "build/keep.txt", false},
+	{"/*\n!/src/", "src/", false},
+	{"/*\n!/src/", "docs/", true},
+}
+
+func TestPatternSemantics(t *testing.T) {
+	for _, tt := range ignoreCases {
+		f := &IgnoreFile{}
+		for i, line := range strings.Split(tt.patterns, "\n") {
+			if p, ok, _ := parsePattern(line); ok {
+				f.Rules = append(f.Rules, Rule{Line: i + 1, Pattern: line, Negate: p.negate, pattern: p})
+			}
+		}
+		rel := strings.TrimSuffix(tt.path, "/")
+		var typ fs.FileMode
+		if strings.HasSuffix(tt.path, "/") {
+			typ = fs.ModeDir
+		}
+		if got, _ := f.Match(rel, typ, nil); got != tt.ignore {
+			t.Errorf("patterns %q, path %q: expected ignore=%v, got %v", tt.patterns, tt.path, tt.ignore, got)
+		}
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	for in, expected := range map[string]string{
+		"plain":       "plain",
+		"a[1]*?":      `a\[1]\*\?`,
+		`back\slash`:  `back\\slash`,
+		"trailing ":   `trailing\ `,
+		"inner space": "inner space",
+	} {
+		got := EscapeGlob(in)
+		if got != expected {
+			t.Errorf("EscapeGlob(%q) = %q, expected %q", in, got, expected)
+		}
+		// The escaped pattern matches the name itself
+		p, ok, err := parsePattern(got)
+		if !ok || err != nil || !p.matches(target{rel: in}) {
+			t.Errorf("Expected %q to match %q, got %v, %v", got, in, ok, err)
+		}
+	}
+}
+
+// TestPatternSemanticsAgainstGit runs the same cases through git itself so
+// that the table stays honest
+func TestPatternSemanticsAgainstGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	for _, tt := range ignoreCases {
+		root := t.TempDir()
+		cmd := exec.Command("git", "init", "-q", root)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git init failed: %v\n%s", err, out)
+		}
+		if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte(tt.patterns+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write .gitignore: %v", err)
+		}
+
+		path := filepath.Join(root, filepath.FromSlash(tt.path))
+		if strings.HasSuffix(tt.path, "/") {
+			os.MkdirAll(path, 0755)
+		} else {
+			os.MkdirAll(filepath.Dir(path), 0755)
+			os.WriteFile(path, nil, 0644)
+		}
+
+		cmd = exec.Command("git", "-c", "core.excludesFile=/dev/null", "check-ignore", "-q", "--no-index", strings.TrimSuffix(tt.path, "/"))
+		cmd.Dir = root
+		err := cmd.Run()
+		ignored := err == nil
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() != 1 {
+			t.Fatalf("git check-ignore failed: %v", err)
+		}
+		if ignored != tt.ignore {
+			t.Errorf("patterns %q, path %q: git says ignore=%v, table says %v", tt.patterns, tt.path, ignored, tt.ignore)
+		}
+	}
+}
+
+func TestRegexAndQualifiers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		typ     fs.FileMode
+		match   bool
+	}{
+		// Regular expressions without a slash match the entry name
+		{`re:\.tmp\.[0-9]+$`, "a/b/data.tmp.123", 0, true},
+		{`re:\.tmp\.[0-9]+$`, "a/b/data.tmp.x", 0, false},
+		{`re:^[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}$`, "cache/0f8fad5b-d9cb-469f-a165-70867728950e", fs.ModeDir, true},
+		{`re:^[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}$`, "cache/not-a-uuid", fs.ModeDir, false},
+		// With a slash they match the relative path
+		{`re:^logs/[0-9]{4}/`, "logs/2024/jan.txt", 0, true},
+		{`re:^logs/[0-9]{4}/`, "old/logs/2024/jan.txt", 0, false},
+
+		// Type qualifiers
+		{"(file) cache", "cache", 0, true},
+		{"(file) cache", "cache", fs.ModeDir, false},
+		{"(dir) cache", "cache", fs.ModeDir, true},
+		{"(dir) cache", "cache", 0, false},
+		{"(link) *", "current", fs.ModeSymlink, true},
+		{"(link) *", "current", 0, false},
+		{"(file, link) *.bak", "x.bak", fs.ModeSymlink, true},
+		{"(file, link) *.bak", "x.bak", fs.ModeDir, false},
+		{"(dir) re:^[0-9]+$", "123", fs.ModeDir, true},
+		{"(dir) re:^[0-9]+$", "123", 0, false},
+
+		// Groups without a known qualifier stay plain globs
+		{"(old)", "(old)", 0, true},
+		{"(draft) notes.txt", "(draft) notes.txt", 0, true},
+		// Escaped prefixes stay plain globs
+		{`\re:foo`, "re:foo", 0, true},
+
+		// Extension classes
+		{"(video) *", "clips/Trip.MKV", 0, true},
+		{"(video) *", "clips/trip.txt", 0, false},
+		{"(archive diskimage) *", "backup.iso", 0, true},
+		// Predicates never match without metadata
+		{"(size>1) *", "big.bin", 0, false},
+	}
+
+	for _, tt := range tests {
+		p, ok, err := parsePattern(tt.pattern)
+		if err != nil || !ok {
+			t.Errorf("parsePattern(%q) failed: ok=%v err=%v", tt.pattern, ok, err)
+			continue
+		}
+		if got := p.matches(target{rel: tt.path, typ: tt.typ}); got != tt.match {
+			t.Errorf("pattern %q, path %q, type %v: expected match=%v, got %v", tt.pattern, tt.path, tt.typ, tt.match, got)
+		}
+	}
+}
+
+// fakeInfo is an fs.FileInfo with a chosen size and modification time
+type fakeInfo struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func (f fakeInfo) Name() string       { return "entry" }
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) Mode() fs.FileMode  { return f.mode }
+func (f fakeInfo) ModTime() time.Time { return f.modTime }
+func (f fakeInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeInfo) Sys() any           { return nil }
+
+func TestPredicates(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	old := now.Add(-100 * 24 * time.Hour)
+	tests := []struct {
+		pattern string
+		info    fakeInfo
+		match   bool
+	}{
+		{"(size>2G) *", fakeInfo{size: 3 << 30}, true},
+		{"(size>2G) *", fakeInfo{size: 2 << 30}, false},
+		{"(size>=2GB) *", fakeInfo{size: 2 << 30}, true},
+		{"(size<1.5MiB) *", fakeInfo{size: 1 << 20}, true},
+		{"(size>500M) *", fakeInfo{size: 1 << 40, mode: fs.ModeDir}, false},
+		{"(age>90d) *", fakeInfo{modTime: old}, true},
+		{"(age>90d) *", fakeInfo{modTime: now}, false},
+		{"(age<2w) *", fakeInfo{modTime: now.Add(-36 * time.Hour)}, true},
+		{"(age>24h) *", fakeInfo{modTime: now.Add(-36 * time.Hour)}, true},
+		{"(mtime<2024-01-01) *", fakeInfo{modTime: time.Date(2023, 12, 31, 23, 0, 0, 0, time.Local)}, true},
+		{"(mtime<2024-01-01) *", fakeInfo{modTime: now}, false},
+		{"(mtime>=2025-01-01T00:00:00Z) *", fakeInfo{modTime: now}, true},
+		{"(video size>500M) *", fakeInfo{size: 1 << 30}, false},
+		{"(file size>1K age>90d) *", fakeInfo{size: 1 << 20, modTime: old}, true},
+		{"(file size>1K age>90d) *", fakeInfo{size: 1 << 20, modTime: now}, false},
+	}
+
+	for _, tt := range tests {
+		p, ok, err := parsePattern(tt.pattern)
+		if err != nil || !ok {
+			t.Errorf("parsePattern(%q) failed: ok=%v err=%v", tt.pattern, ok, err)
+			continue
+		}
+		info := tt.info
+		tg := target{
+			rel:  "dir/entry.bin",
+			typ:  info.mode.Type(),
+			info: func() (fs.FileInfo, error) { return info, nil },
+			now:  now,
+		}
+		if got := p.matches(tg); got != tt.match {
+			t.Errorf("pattern %q, size %d, mtime %v: expected match=%v, got %v", tt.pattern, info.size, info.modTime, tt.match, got)
+		}
+	}
+}
+
+func TestMatcherPredicates(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "(video size>1K) Scratch/**\n(age>30d) old/\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dropboxignore"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	for _, dir := range []string{"Scratch", "old", "new"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	files := map[string]int{
+		"Scratch/big.mp4":   4096,
+		"Scratch/small.mp4": 10,
+		"Scratch/big.txt":   4096,
+		"old/notes.txt":     1,
+		"new/notes.txt":     1,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	past := time.Now().Add(-60 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpDir, "old"), past, past); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	m, err := NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	tests := []struct {
+		path   string
+		ignore bool
+	}{
+		{"Scratch/big.mp4", true},
+		{"Scratch/small.mp4", false},
+		{"Scratch/big.txt", false},
+		{"old", true},
+		// Inside an old directory, found by stat'ing the parent
+		{"old/notes.txt", true},
+		{"new/notes.txt", false},
+	}
+	for _, tt := range tests {
+		got, err := m.ShouldIgnore(filepath.Join(tmpDir, tt.path))
+		if err != nil {
+			t.Errorf("ShouldIgnore(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if got != tt.ignore {
+			t.Errorf("ShouldIgnore(%s) = %v, expected %v", tt.path, got, tt.ignore)
+		}
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	for _, line := range []string{
+		"re:([a-z]",
+		"re:a{2,1}",
+		"(dir, fast) build",
+		"(dir)",
+		"(size>2Q) *",
+		"(age>soon) *",
+		"(mtime<yesterday) *",
+	} {
+		if _, _, err := parsePattern(line); err == nil {
+			t.Errorf("Expected error for %q", line)
+		}
+	}
+}
+
+func TestLoadIgnoreFileErrors(t *testing.T) {
+	ignoreFile := filepath.Join(t.TempDir(), ".dropboxignore")
+	content := "*.log\nre:(unclosed\n(dir) build\n"
+	if err := os.WriteFile(ignoreFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	m, err := NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	f, err := m.LoadIgnoreFile(ignoreFile)
+	if err != nil {
+		t.Fatalf("LoadIgnoreFile failed: %v", err)
+	}
+
+	// The invalid line is reported and skipped, the others still apply
+	if len(f.Rules) != 2 {
+		t.Errorf("Expected 2 rules, got %d", len(f.Rules))
+	}
+	if len(f.Errors) != 1 || f.Errors[0].Line != 2 {
+		t.Fatalf("Expected one error on line 2, got %v", f.Errors)
+	}
+	if !strings.Contains(f.Errors[0].Error(), ".dropboxignore:2: invalid regular expression") {
+		t.Errorf("Unexpected error message: %v", f.Errors[0])
+	}
+}
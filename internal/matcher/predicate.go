@@ -0,0 +1,167 @@
+package matcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// predicate tests an entry's metadata
+type predicate func(info fs.FileInfo, now time.Time) bool
+
+// predicateRe splits words such as size>2G, age<=90d or mtime<2024-01-01
+var predicateRe = regexp.MustCompile(`^(size|age|mtime)(<=|>=|<|>)(.+)$`)
+
+// extensionClasses groups file extensions under names usable in a
+// qualifier group, e.g. (video size>500M)
+var extensionClasses = map[string][]string{
+	"video":     {"mp4", "mkv", "mov", "avi", "wmv", "flv", "webm", "m4v", "mpg", "mpeg", "m2ts", "mts", "3gp"},
+	"audio":     {"mp3", "wav", "flac", "aac", "ogg", "m4a", "wma", "aiff", "aif", "opus"},
+	"image":     {"jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff", "heic", "heif", "webp", "raw", "cr2", "nef", "arw", "dng", "psd"},
+	"archive":   {"zip", "tar", "gz", "tgz", "bz2", "tbz2", "xz", "txz", "zst", "7z", "rar"},
+	"diskimage": {"iso", "img", "dmg", "vmdk", "vdi", "qcow2", "vhd", "vhdx"},
+}
+
+// Qualifiers returns the words accepted in a qualifier group besides
+// predicates: entry types and extension classes
+func Qualifiers() []string {
+	names := make([]string, 0, len(qualifiers)+len(extensionClasses))
+	for name := range qualifiers {
+		names = append(names, name)
+	}
+	for name := range extensionClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sizeUnits are binary multipliers accepted after a size
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// parsePredicate parses a metadata word. It reports false when the word
+// is not a predicate at all.
+func parsePredicate(word string) (predicate, bool, error) {
+	m := predicateRe.FindStringSubmatch(word)
+	if m == nil {
+		return nil, false, nil
+	}
+	field, op, value := m[1], m[2], m[3]
+
+	switch field {
+	case "size":
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, true, err
+		}
+		return func(info fs.FileInfo, _ time.Time) bool {
+			// Directory sizes say nothing about their contents
+			return info.Mode().IsRegular() && compare(info.Size(), size, op)
+		}, true, nil
+
+	case "age":
+		age, err := ParseAge(value)
+		if err != nil {
+			return nil, true, err
+		}
+		return func(info fs.FileInfo, now time.Time) bool {
+			return compare(int64(now.Sub(info.ModTime())), int64(age), op)
+		}, true, nil
+
+	case "mtime":
+		t, err := ParseTime(value)
+		if err != nil {
+			return nil, true, err
+		}
+		return func(info fs.FileInfo, _ time.Time) bool {
+			return compare(info.ModTime().UnixNano(), t.UnixNano(), op)
+		}, true, nil
+	}
+	return nil, false, nil
+}
+
+func compare(a, b int64, op string) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// ParseSize parses sizes such as 500M, 2GB, 1.5GiB or 4096
+func ParseSize(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	lower = strings.TrimSuffix(strings.TrimSuffix(lower, "ib"), "b")
+	i := len(lower)
+	for i > 0 && (lower[i-1] < '0' || lower[i-1] > '9') && lower[i-1] != '.' {
+		i--
+	}
+	unit, ok := sizeUnits[lower[i:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(lower[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// ParseAge parses ages such as 90d, 2w, 1y or any Go duration like 36h
+func ParseAge(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if s == "" {
+		return 0, fmt.Errorf("empty age")
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// ParseTime parses a local date (2024-01-31) or an RFC 3339 timestamp
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or RFC 3339", s)
+}
+
+// hasExtension reports whether name ends in one of exts
+func hasExtension(name string, exts map[string]bool) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	return ext != "" && exts[ext]
+}
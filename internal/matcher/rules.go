@@ -0,0 +1,183 @@
+package matcher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is a single pattern line from an ignore file
+type Rule struct {
+	File    string
+	Line    int
+	Pattern string
+	// Section is the header the rule appeared under, empty when unconditional
+	Section string
+	// Negate is set for !pattern rules that re-include a path
+	Negate bool
+
+	pattern pattern
+}
+
+// Describe formats a rule as file:line: pattern, followed by its section
+func (r *Rule) Describe() string {
+	desc := fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Pattern)
+	if r.Section != "" {
+		desc += " in section " + r.Section
+	}
+	return desc
+}
+
+// target is an entry that rules are evaluated against
+type target struct {
+	// rel is slash-separated and relative to the rules' directory
+	rel string
+	typ fs.FileMode
+	// info returns the entry's metadata for predicates; nil when unavailable
+	info func() (fs.FileInfo, error)
+	now  time.Time
+}
+
+// ParseError describes a line that could not be compiled
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// IgnoreFile holds the rules of one ignore file that apply to the host it
+// was loaded on
+type IgnoreFile struct {
+	Path  string
+	Rules []Rule
+	// Errors lists lines that were skipped because they did not compile
+	Errors []*ParseError
+}
+
+// readRules parses an ignore file read from r. Section headers are honoured
+// when host is non-nil; .gitignore files are loaded without one. With
+// allSections the rules of every section are kept regardless of host.
+func readRules(r io.Reader, path string, host *Host, allSections bool) (*IgnoreFile, error) {
+	f := &IgnoreFile{Path: path}
+	current := section{}
+	lineNo := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+
+		if host != nil {
+			if trimmed := strings.TrimSpace(line); isSectionHeader(trimmed) {
+				current = parseSection(trimmed)
+				continue
+			}
+			if !allSections && !current.matches(*host) {
+				continue
+			}
+		}
+
+		// Blank lines and comments produce no pattern
+		p, ok, err := parsePattern(line)
+		if err != nil {
+			f.Errors = append(f.Errors, &ParseError{File: path, Line: lineNo, Err: err})
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		rule := Rule{
+			File:    path,
+			Line:    lineNo,
+			Pattern: trimTrailingSpaces(strings.TrimSuffix(line, "\r")),
+			Negate:  p.negate,
+			pattern: p,
+		}
+		if !current.unconditional() {
+			rule.Section = current.text
+		}
+		f.Rules = append(f.Rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// MatchesPath reports whether a path relative to the ignore file's directory
+// is ignored, treating it as a file
+func (f *IgnoreFile) MatchesPath(rel string) bool {
+	ignored, _ := f.Match(rel, 0, nil)
+	return ignored
+}
+
+// Match reports whether a relative path is ignored and, if so, which rule
+// caused it. As in git, a path inside an ignored directory is ignored and
+// cannot be re-included by a negated rule. Rules with metadata predicates
+// only match when info is non-nil.
+func (f *IgnoreFile) Match(rel string, typ fs.FileMode, info fs.FileInfo) (bool, *Rule) {
+	t := target{rel: filepath.ToSlash(rel), typ: typ, now: time.Now()}
+	if info != nil {
+		t.info = func() (fs.FileInfo, error) { return info, nil }
+	}
+	rule := decide(t, "", f.lastMatch)
+	if rule == nil || rule.Negate {
+		return false, nil
+	}
+	return true, rule
+}
+
+// lastMatch returns the last rule matching an entry, including negated
+// rules, or nil when no rule has an opinion about it. Parent directories
+// are not considered.
+func (f *IgnoreFile) lastMatch(t target) *Rule {
+	for i := len(f.Rules) - 1; i >= 0; i-- {
+		if f.Rules[i].pattern.matches(t) {
+			return &f.Rules[i]
+		}
+	}
+	return nil
+}
+
+// decide returns the rule that decides an entry. Each parent directory is
+// checked first, outermost first, and the first one that is ignored
+// decides; otherwise the entry's own last match does. When base is set,
+// parent directories are stat'ed under it for rules with predicates.
+func decide(t target, base string, lastMatch func(target) *Rule) *Rule {
+	for i := 0; i < len(t.rel); i++ {
+		if t.rel[i] != '/' {
+			continue
+		}
+		parent := target{rel: t.rel[:i], typ: fs.ModeDir, now: t.now}
+		if base != "" {
+			parent.info = lazyStat(filepath.Join(base, filepath.FromSlash(parent.rel)))
+		}
+		if rule := lastMatch(parent); rule != nil && !rule.Negate {
+			return rule
+		}
+	}
+	return lastMatch(t)
+}
+
+// lazyStat returns a function that stats path on first use
+func lazyStat(path string) func() (fs.FileInfo, error) {
+	return sync.OnceValues(func() (fs.FileInfo, error) {
+		return os.Stat(path)
+	})
+}
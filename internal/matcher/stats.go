@@ -0,0 +1,145 @@
+package matcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// defaultPattern stands in for the pattern of paths ignored by default
+// because a .dropboxinclude does not list them
+const defaultPattern = "(not listed)"
+
+// RuleStats describes what a rule has ignored
+type RuleStats struct {
+	File    string
+	Line    int
+	Pattern string
+	// Matches counts how often the rule decided that a path is ignored
+	Matches uint64
+	// Entries and Bytes describe the distinct paths that scans recorded as
+	// ignored by the rule; a directory counts once with the size of its
+	// contents
+	Entries int
+	Bytes   int64
+}
+
+// ruleKey identifies a rule across reloads of its file
+type ruleKey struct {
+	file    string
+	line    int
+	pattern string
+}
+
+type ruleCounts struct {
+	matches uint64
+	// paths maps each recorded path to its size
+	paths map[string]int64
+}
+
+// keyOf returns the statistics key for an ignored result
+func keyOf(res Result) ruleKey {
+	if res.Rule == nil {
+		return ruleKey{file: res.IncludeFile, pattern: defaultPattern}
+	}
+	return ruleKey{file: res.Rule.File, line: res.Rule.Line, pattern: res.Rule.Pattern}
+}
+
+// counts returns the counters for a key; statsMu must be held
+func (m *Matcher) counts(key ruleKey) *ruleCounts {
+	c, ok := m.stats[key]
+	if !ok {
+		c = &ruleCounts{paths: make(map[string]int64)}
+		m.stats[key] = c
+	}
+	return c
+}
+
+func (m *Matcher) countMatch(res Result) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.counts(keyOf(res)).matches++
+}
+
+// Record notes that a scan left path out of Dropbox because of res. The
+// size of a directory's contents is measured the first time it is
+// recorded, by walking it, so Record belongs in one-off scans such as the
+// stats command rather than in the daemon's per-path handling. It does
+// nothing unless the matcher was created with Config.Stats.
+func (m *Matcher) Record(path string, res Result, info fs.FileInfo) {
+	if !m.countStats || !res.Ignored {
+		return
+	}
+	key := keyOf(res)
+
+	m.statsMu.Lock()
+	size, known := m.counts(key).paths[path]
+	m.statsMu.Unlock()
+
+	switch {
+	case info.Mode().IsRegular():
+		size = info.Size()
+	case info.IsDir() && !known:
+		size, _ = DirSize(path)
+	}
+
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	// A path belongs to the rule that ignored it last
+	for k, c := range m.stats {
+		if k != key {
+			delete(c.paths, path)
+		}
+	}
+	m.counts(key).paths[path] = size
+}
+
+// Stats returns statistics for every rule that has ignored something,
+// the rules keeping the most data out of Dropbox first
+func (m *Matcher) Stats() []RuleStats {
+	m.statsMu.Lock()
+	stats := make([]RuleStats, 0, len(m.stats))
+	for key, c := range m.stats {
+		s := RuleStats{
+			File:    key.file,
+			Line:    key.line,
+			Pattern: key.pattern,
+			Matches: c.matches,
+			Entries: len(c.paths),
+		}
+		for _, size := range c.paths {
+			s.Bytes += size
+		}
+		stats = append(stats, s)
+	}
+	m.statsMu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		switch {
+		case a.Bytes != b.Bytes:
+			return a.Bytes > b.Bytes
+		case a.Entries != b.Entries:
+			return a.Entries > b.Entries
+		case a.File != b.File:
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return stats
+}
+
+// DirSize sums the sizes and counts the regular files below dir
+func DirSize(dir string) (size int64, files int) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
@@ -0,0 +1,81 @@
+package matcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	ignoreFile := filepath.Join(tmpDir, ".dropboxignore")
+	if err := os.WriteFile(ignoreFile, []byte("*.log\nbuild/\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	files := map[string]int{
+		"a.log":       100,
+		"b.log":       20,
+		"build/out.o": 3000,
+		"build/x/y.o": 1000,
+		"main.go":     10,
+	}
+	for name, size := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	m, err := NewMatcherWithConfig(Config{Stats: true})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	// Two scans see the same entries
+	for i := 0; i < 2; i++ {
+		for _, name := range []string{"a.log", "b.log", "build", "main.go"} {
+			path := filepath.Join(tmpDir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Failed to stat %s: %v", name, err)
+			}
+			res, err := m.Explain(path)
+			if err != nil {
+				t.Fatalf("Explain(%s) failed: %v", name, err)
+			}
+			m.Record(path, res, info)
+		}
+	}
+
+	stats := m.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for 2 rules, got %+v", stats)
+	}
+	expected := []RuleStats{
+		{File: ignoreFile, Line: 2, Pattern: "build/", Matches: 2, Entries: 1, Bytes: 4000},
+		{File: ignoreFile, Line: 1, Pattern: "*.log", Matches: 4, Entries: 2, Bytes: 120},
+	}
+	for i, s := range stats {
+		if s != expected[i] {
+			t.Errorf("Stats %d: expected %+v, got %+v", i, expected[i], s)
+		}
+	}
+
+	// Matchers that do not report statistics do not keep them
+	m, err = NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	path := filepath.Join(tmpDir, "a.log")
+	info, _ := os.Stat(path)
+	res, err := m.Explain(path)
+	if err != nil || !res.Ignored {
+		t.Fatalf("Expected a.log to be ignored, got %v, %v", res, err)
+	}
+	m.Record(path, res, info)
+	if stats := m.Stats(); len(stats) != 0 {
+		t.Errorf("Expected no statistics, got %+v", stats)
+	}
+}
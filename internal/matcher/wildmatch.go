@@ -0,0 +1,274 @@
+package matcher
+
+import "strings"
+
+// Flags for wildmatch, mirroring git's WM_* flags
+const (
+	// wmPathname stops '*', '?' and bracket expressions from matching '/'
+	// and gives '**' its special meaning
+	wmPathname = 1 << iota
+	// wmCasefold matches case-insensitively
+	wmCasefold
+)
+
+// Internal results of dowild; anything but wmMatch is a failure
+const (
+	wmMatch = iota
+	wmNoMatch
+	wmAbortAll
+	wmAbortToStarStar
+)
+
+// wildmatch reports whether text matches a git-style glob pattern. It is a
+// port of git's wildmatch.c so that '**', bracket expressions, character
+// classes and backslash escapes behave exactly as they do in git.
+func wildmatch(pattern, text string, flags int) bool {
+	return dowild(pattern, text, flags) == wmMatch
+}
+
+// at returns s[i], or 0 past the end like a C string
+func at(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func toUpper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
+
+func dowild(p, text string, flags int) int {
+	pi, ti := 0, 0
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		pCh := p[pi]
+		tCh := at(text, ti)
+		if tCh == 0 && pCh != '*' {
+			return wmAbortAll
+		}
+		if flags&wmCasefold != 0 {
+			tCh = toLower(tCh)
+			pCh = toLower(pCh)
+		}
+
+		switch pCh {
+		case '\\':
+			// Literal match with the following character
+			pi++
+			if at(p, pi) != tCh {
+				return wmNoMatch
+			}
+
+		case '?':
+			if flags&wmPathname != 0 && tCh == '/' {
+				return wmNoMatch
+			}
+
+		case '*':
+			matchSlash := flags&wmPathname == 0
+			pi++
+			if at(p, pi) == '*' {
+				prev := pi - 2
+				for at(p, pi) == '*' {
+					pi++
+				}
+				if flags&wmPathname != 0 && (prev < 0 || p[prev] == '/') &&
+					(pi == len(p) || p[pi] == '/' || (p[pi] == '\\' && at(p, pi+1) == '/')) {
+					// "**/" may match nothing at all: try the rest of the
+					// pattern against the remaining text first
+					if at(p, pi) == '/' && dowild(p[pi+1:], text[ti:], flags) == wmMatch {
+						return wmMatch
+					}
+					matchSlash = true
+				}
+				// Otherwise "**" behaves like a single "*"
+			}
+
+			if pi == len(p) {
+				// Trailing "**" matches everything, trailing "*" only
+				// if no slash remains
+				if !matchSlash && strings.IndexByte(text[ti:], '/') >= 0 {
+					return wmNoMatch
+				}
+				return wmMatch
+			}
+			if !matchSlash && p[pi] == '/' {
+				// A single "*" followed by a slash matches up to the next
+				// directory separator
+				slash := strings.IndexByte(text[ti:], '/')
+				if slash < 0 {
+					return wmNoMatch
+				}
+				// The slash itself is consumed by the loop increment
+				ti += slash
+				continue
+			}
+
+			for ; ti < len(text); ti++ {
+				tCh = text[ti]
+				matched := dowild(p[pi:], text[ti:], flags)
+				if matched != wmNoMatch {
+					if !matchSlash || matched != wmAbortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && tCh == '/' {
+					return wmAbortToStarStar
+				}
+			}
+			return wmAbortAll
+
+		case '[':
+			pi++
+			pCh = at(p, pi)
+			if pCh == '^' {
+				pCh = '!'
+			}
+			negated := pCh == '!'
+			if negated {
+				pi++
+				pCh = at(p, pi)
+			}
+
+			var prevCh byte
+			matched := false
+			for {
+				if pCh == 0 {
+					return wmAbortAll
+				}
+				if pCh == '\\' {
+					pi++
+					pCh = at(p, pi)
+					if pCh == 0 {
+						return wmAbortAll
+					}
+					if tCh == pCh {
+						matched = true
+					}
+				} else if pCh == '-' && prevCh != 0 && at(p, pi+1) != 0 && at(p, pi+1) != ']' {
+					pi++
+					pCh = p[pi]
+					if pCh == '\\' {
+						pi++
+						pCh = at(p, pi)
+						if pCh == 0 {
+							return wmAbortAll
+						}
+					}
+					if tCh <= pCh && tCh >= prevCh {
+						matched = true
+					} else if flags&wmCasefold != 0 && 'a' <= tCh && tCh <= 'z' {
+						upper := toUpper(tCh)
+						if upper <= pCh && upper >= prevCh {
+							matched = true
+						}
+					}
+					// Ranges cannot chain into another range
+					pCh = 0
+				} else if pCh == '[' && at(p, pi+1) == ':' {
+					start := pi + 2
+					pi = start
+					for at(p, pi) != 0 && p[pi] != ']' {
+						pi++
+					}
+					if at(p, pi) == 0 {
+						return wmAbortAll
+					}
+					n := pi - start - 1
+					if n < 0 || p[pi-1] != ':' {
+						// No ":]" terminator, so '[' is an ordinary member
+						pi = start - 2
+						pCh = '['
+						if tCh == pCh {
+							matched = true
+						}
+						prevCh = pCh
+						pi++
+						pCh = at(p, pi)
+						if pCh == ']' {
+							break
+						}
+						continue
+					}
+					ok, valid := matchClass(p[start:start+n], tCh, flags)
+					if !valid {
+						return wmAbortAll
+					}
+					if ok {
+						matched = true
+					}
+					pCh = 0
+				} else if tCh == pCh {
+					matched = true
+				}
+
+				prevCh = pCh
+				pi++
+				pCh = at(p, pi)
+				if pCh == ']' {
+					break
+				}
+			}
+			if matched == negated || (flags&wmPathname != 0 && tCh == '/') {
+				return wmNoMatch
+			}
+
+		default:
+			if tCh != pCh {
+				return wmNoMatch
+			}
+		}
+	}
+
+	if ti < len(text) {
+		return wmNoMatch
+	}
+	return wmMatch
+}
+
+// matchClass evaluates a POSIX character class such as [:alpha:]. It
+// reports whether c is a member and whether the class name is known.
+func matchClass(name string, c byte, flags int) (bool, bool) {
+	isUpper := 'A' <= c && c <= 'Z'
+	isLower := 'a' <= c && c <= 'z'
+	isDigit := '0' <= c && c <= '9'
+	isAlpha := isUpper || isLower
+	isPrint := c >= 0x20 && c < 0x7f
+
+	switch name {
+	case "alnum":
+		return isAlpha || isDigit, true
+	case "alpha":
+		return isAlpha, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return isPrint && c != ' ', true
+	case "lower":
+		return isLower || (flags&wmCasefold != 0 && isUpper), true
+	case "print":
+		return isPrint, true
+	case "punct":
+		return isPrint && c != ' ' && !isAlpha && !isDigit, true
+	case "space":
+		return c == ' ' || ('\t' <= c && c <= '\r'), true
+	case "upper":
+		return isUpper || (flags&wmCasefold != 0 && isLower), true
+	case "xdigit":
+		return isDigit || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F'), true
+	}
+	return false, false
+}
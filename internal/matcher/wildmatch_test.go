@@ -0,0 +1,101 @@
+package matcher
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// splitShellWords splits a line into words using the single and double
+// quoting rules needed by testdata/wildmatch.txt
+func splitShellWords(line string) ([]string, bool) {
+	var (
+		words   []string
+		current strings.Builder
+		inWord  bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			current.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				return nil, false
+			}
+			current.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		default:
+			current.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, true
+}
+
+func TestWildmatchConformance(t *testing.T) {
+	file, err := os.Open("testdata/wildmatch.txt")
+	if err != nil {
+		t.Fatalf("Failed to open test cases: %v", err)
+	}
+	defer file.Close()
+
+	modes := []struct {
+		name  string
+		flags int
+	}{
+		{"wildmatch", wmPathname},
+		{"iwildmatch", wmPathname | wmCasefold},
+		{"pathmatch", 0},
+		{"ipathmatch", wmCasefold},
+	}
+
+	cases := 0
+	lineNo := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, ok := splitShellWords(line)
+		if !ok || len(words) != 7 || words[0] != "match" {
+			t.Fatalf("line %d: malformed test case: %s", lineNo, line)
+		}
+		text, pattern := words[5], words[6]
+
+		for i, mode := range modes {
+			expected := words[1+i] == "1"
+			if got := wildmatch(pattern, text, mode.flags); got != expected {
+				t.Errorf("line %d: %s(%q, %q) = %v, expected %v", lineNo, mode.name, pattern, text, got, expected)
+			}
+		}
+		cases++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read test cases: %v", err)
+	}
+	if cases == 0 {
+		t.Fatal("No test cases found")
+	}
+}
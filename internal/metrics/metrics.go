@@ -0,0 +1,300 @@
+// Package metrics is a minimal registry of counters, gauges and histograms
+// exposed in the Prometheus text format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is a metric family that can write itself
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Handler serves the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Serve exposes the registry on addr at /metrics until ctx is done
+func (r *Registry) Serve(ctx context.Context, addr string, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving metrics", "url", "http://"+addr+"/metrics")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// header writes the HELP and TYPE lines of a family
+func header(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	n, help string
+	v       atomic.Uint64
+}
+
+// NewCounter registers a counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{n: name, help: help}
+	r.register(c)
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add adds n
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w *bufio.Writer) {
+	header(w, c.n, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.n, c.v.Load())
+}
+
+// CounterVec is a counter partitioned by one label
+type CounterVec struct {
+	n, help, label string
+	mu             sync.Mutex
+	values         map[string]uint64
+}
+
+// NewCounterVec registers a counter with one label
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{n: name, help: help, label: label, values: make(map[string]uint64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for a label value
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) name() string { return c.n }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	counts := make([]uint64, len(values))
+	for i, v := range values {
+		counts[i] = c.values[v]
+	}
+	c.mu.Unlock()
+
+	header(w, c.n, c.help, "counter")
+	for i, v := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.n, c.label, escapeLabel(v), counts[i])
+	}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	n, help string
+	bits    atomic.Uint64
+}
+
+// NewGauge registers a gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{n: name, help: help}
+	r.register(g)
+	return g
+}
+
+// Set sets the value
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+func (g *Gauge) name() string { return g.n }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	header(w, g.n, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(math.Float64frombits(g.bits.Load())))
+}
+
+// funcMetric reads its value when scraped
+type funcMetric struct {
+	n, help, typ string
+	f            func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from f when scraped
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{n: name, help: help, typ: "gauge", f: f})
+}
+
+// NewCounterFunc registers a counter whose value is read from f when
+// scraped; f must never decrease
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{n: name, help: help, typ: "counter", f: f})
+}
+
+func (m *funcMetric) name() string { return m.n }
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	header(w, m.n, m.help, m.typ)
+	fmt.Fprintf(w, "%s %s\n", m.n, formatFloat(m.f()))
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	n, help string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// DefBuckets suit durations in seconds from milliseconds to minutes
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// NewHistogram registers a histogram with upper bucket bounds in
+// increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets of " + name + " are not sorted")
+	}
+	h := &Histogram{n: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(h)
+	return h
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) name() string { return h.n }
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	header(w, h.n, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.n, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.n, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.n, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.n, count)
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_events_total", "Events seen.")
+	c.Add(3)
+	c.Inc()
+	v := r.NewCounterVec("test_errors_total", "Errors by errno.", "errno")
+	v.Inc("EACCES")
+	v.Inc("EACCES")
+	v.Inc(`we"ird`)
+	g := r.NewGauge("test_queue", "Queue depth\nwith a newline.")
+	g.Set(2.5)
+	r.NewGaugeFunc("test_size", "Size.", func() float64 { return 7 })
+	h := r.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	expected := `# HELP test_events_total Events seen.
+# TYPE test_events_total counter
+test_events_total 4
+# HELP test_errors_total Errors by errno.
+# TYPE test_errors_total counter
+test_errors_total{errno="EACCES"} 2
+test_errors_total{errno="we\"ird"} 1
+# HELP test_queue Queue depth\nwith a newline.
+# TYPE test_queue gauge
+test_queue 2.5
+# HELP test_size Size.
+# TYPE test_size gauge
+test_size 7
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 2
+test_duration_seconds_bucket{le="1"} 3
+test_duration_seconds_bucket{le="+Inf"} 4
+test_duration_seconds_sum 3.65
+test_duration_seconds_count 4
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestDuplicatePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic")
+		}
+	}()
+	r.NewGauge("dup", "")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Errorf("Unexpected body %q", rec.Body.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(ctx, addr, slog.New(slog.DiscardHandler)) }()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to fetch metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "test_total 1") {
+		t.Errorf("Unexpected body %q", body)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package poller
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+)
+
+// racyWindow is how recently before being read a directory may have been
+// modified for its listing to be reused. A change within the same
+// timestamp tick as the read would leave the mtime as recorded, so such
+// directories are read again. Covers filesystems with 2s resolution.
+const racyWindow = 2 * time.Second
+
+// listing is a directory's entries as read by a scan
+type listing struct {
+	modTime time.Time
+	inode   uint64
+	// listed is when the scan that read the directory started
+	listed time.Time
+	// entries are sorted by name
+	entries []dirent
+}
+
+// current reports whether the listing still holds for the directory with
+// the given info
+func (l *listing) current(info fs.FileInfo) bool {
+	return state.Inode(info) == l.inode &&
+		info.ModTime().Equal(l.modTime) &&
+		l.modTime.Before(l.listed.Add(-racyWindow))
+}
+
+// listing returns the recorded listing of a directory, or nil
+func (p *Poller) listing(path string) *listing {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.listings[path]
+}
+
+// setListing records the listing of a directory
+func (p *Poller) setListing(path string, l *listing) {
+	p.mu.Lock()
+	p.listings[path] = l
+	p.mu.Unlock()
+}
+
+// cleanupListings forgets the listings of directories a scan did not
+// reach, because they were removed, skipped or ignored
+func (p *Poller) cleanupListings(visitedDirs map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for path := range p.listings {
+		if _, visited := visitedDirs[path]; !visited {
+			delete(p.listings, path)
+		}
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"context"
 	"errors"
 	"io/fs"
-	"log"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/symlink"
 )
 
 const (
@@ -22,20 +28,41 @@ const (
 // ErrSkipDir is returned by Handler to indicate that directory contents should be skipped
 var ErrSkipDir = errors.New("skip directory")
 
-// Handler is called for each file that needs processing
-type Handler func(path string, info fs.FileInfo) error
+// Handler is called for each file and directory that needs processing.
+// The entry's type comes from the directory listing; d.Info reads the rest
+// of its metadata with an lstat, so a handler that decides on the name and
+// type alone costs no stat calls.
+type Handler func(path string, d fs.DirEntry) error
+
+// ScanStats describes a completed scan
+type ScanStats struct {
+	Duration time.Duration
+	Files    int
+	Dirs     int
+	Skipped  int
+	// Listed is the number of directories read rather than reused from
+	// the previous scan
+	Listed int
+	// Lstats is the number of entries whose metadata was read
+	Lstats int
+}
 
 // Poller performs periodic filesystem scans
 type Poller struct {
 	root     string
 	interval time.Duration
 	handler  Handler
+	onScan   func(ScanStats)
+	symlinks symlink.Resolver
+	workers  int
+	recheck  func() bool
 	
-	mu         sync.RWMutex
-	lastScan   time.Time
-	dirModTime map[string]time.Time
+	mu       sync.RWMutex
+	lastScan time.Time
+	// listings are the directory entries read by previous scans
+	listings map[string]*listing
 	
-	logger *log.Logger
+	logger *slog.Logger
 	
 	// Directories to skip
 	skipDirs map[string]bool
@@ -46,8 +73,22 @@ type Config struct {
 	Root         string
 	ScanInterval time.Duration
 	Handler      Handler
-	Logger       *log.Logger
+	Logger       *slog.Logger
 	SkipDirs     []string
+	// OnScan is called after each scan; optional
+	OnScan func(ScanStats)
+	// Symlinks decides which info links are handled with, if at all.
+	// Links to directories are never descended into.
+	Symlinks symlink.Resolver
+	// Workers is how many directories are read at once. 0 or 1 walks the
+	// tree on the calling goroutine, keeping memory and CPU use low.
+	Workers int
+	// RecheckModified is asked at the start of each scan whether files
+	// already handled are stat'ed and handled again when modified since
+	// the last scan. Writing a file in place leaves its directory's
+	// listing unchanged, so this is needed for rules that look at size or
+	// times. Nil never rechecks.
+	RecheckModified func() bool
 }
 
 // NewPoller creates a new filesystem poller
@@ -56,7 +97,7 @@ func NewPoller(cfg Config) (*Poller, error) {
 		cfg.ScanInterval = defaultScanInterval
 	}
 	if cfg.Logger == nil {
-		cfg.Logger = log.Default()
+		cfg.Logger = slog.Default()
 	}
 	
 	// Resolve root to absolute path
@@ -83,7 +124,11 @@ func NewPoller(cfg Config) (*Poller, error) {
 		root:       root,
 		interval:   cfg.ScanInterval,
 		handler:    cfg.Handler,
-		dirModTime: make(map[string]time.Time),
+		onScan:     cfg.OnScan,
+		symlinks:   cfg.Symlinks,
+		workers:    cfg.Workers,
+		recheck:    cfg.RecheckModified,
+		listings:   make(map[string]*listing),
 		logger:     cfg.Logger,
 		skipDirs:   skipDirs,
 	}, nil
@@ -93,7 +138,7 @@ func NewPoller(cfg Config) (*Poller, error) {
 func (p *Poller) Run(ctx context.Context) error {
 	// Initial scan
 	if err := p.Scan(); err != nil {
-		p.logger.Printf("Initial scan error: %v", err)
+		p.logger.Error("Initial scan failed", "error", err)
 	}
 	
 	ticker := time.NewTicker(p.interval)
@@ -105,7 +150,7 @@ func (p *Poller) Run(ctx context.Context) error {
 			return ctx.Err()
 		case <-ticker.C:
 			if err := p.Scan(); err != nil {
-				p.logger.Printf("Scan error: %v", err)
+				p.logger.Error("Scan failed", "error", err)
 			}
 		}
 	}
@@ -121,121 +166,304 @@ func (p *Poller) Scan() error {
 	p.lastScan = scanStart
 	p.mu.Unlock()
 	
-	p.logger.Printf("Starting scan of %s", p.root)
+	p.logger.Info("Starting scan", "root", p.root, "workers", p.workers)
 	
-	fileCount := 0
-	dirCount := 0
-	skippedDirs := 0
+	s := &scan{
+		p:           p,
+		start:       scanStart,
+		lastScan:    lastScan,
+		recheck:     p.recheck != nil && p.recheck(),
+		visitedDirs: make(map[string]struct{}),
+	}
+	s.walk(p.workers)
 	
-	// Track visited directories for cleanup
-	visitedDirs := make(map[string]struct{})
+	// Forget listings of directories no longer walked
+	p.cleanupListings(s.visitedDirs)
 	
-	err := filepath.WalkDir(p.root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			// Log but continue scanning
-			p.logger.Printf("Walk error at %s: %v", path, err)
-			return nil
-		}
-		
-		// Check if directory should be skipped
-		if d.IsDir() {
-			dirCount++
-			visitedDirs[path] = struct{}{}
-			
-			// Get directory info first
-			info, err := d.Info()
-			if err != nil {
-				p.logger.Printf("Failed to get info for directory %s: %v", path, err)
-				return nil
-			}
-			
-			// Process directory first (for applying ignore attributes)
-			err = p.handler(path, info)
-			if err != nil {
-				if errors.Is(err, ErrSkipDir) {
-					p.logger.Printf("Skipping contents of ignored directory: %s", path)
-					skippedDirs++
-					return filepath.SkipDir
-				}
-				p.logger.Printf("Handler error for directory %s: %v", path, err)
-			}
-			
-			// Now check if we should skip descending into this directory
-			name := d.Name()
-			if p.skipDirs[name] || strings.HasPrefix(name, ".") && name != "." {
-				skippedDirs++
-				return filepath.SkipDir
-			}
-			
-			// Check directory modification time for incremental scan
-			if !lastScan.IsZero() {
-				// Check if directory can be skipped
-				if p.shouldSkipDir(path, info.ModTime()) {
-					return filepath.SkipDir
-				}
-				// Update modification time
-				p.updateDirModTime(path, info.ModTime())
+	duration := time.Since(scanStart)
+	stats := ScanStats{
+		Duration: duration,
+		Files:    int(s.files.Load()),
+		Dirs:     int(s.dirs.Load()),
+		Skipped:  int(s.skipped.Load()),
+		Listed:   int(s.listed.Load()),
+		Lstats:   int(s.lstats.Load()),
+	}
+	p.logger.Info("Scan completed", "duration", duration, "files", stats.Files,
+		"dirs", stats.Dirs, "skipped", stats.Skipped, "listed", stats.Listed, "lstats", stats.Lstats)
+	if p.onScan != nil {
+		p.onScan(stats)
+	}
+	
+	return nil
+}
+
+// scan is the state of one scan, shared by the workers of a parallel walk
+type scan struct {
+	p        *Poller
+	start    time.Time
+	lastScan time.Time
+	// recheck stats known files for modifications
+	recheck bool
+	
+	files   atomic.Int64
+	dirs    atomic.Int64
+	skipped atomic.Int64
+	listed  atomic.Int64
+	lstats  atomic.Int64
+	
+	// Directories whose listing is in use, for cleanup
+	mu          sync.Mutex
+	visitedDirs map[string]struct{}
+}
+
+// walk visits the tree from the root. With more than one worker,
+// directories are read by a pool of workers, each reading one directory at
+// a time. A directory is visited before it is read, so the handler still
+// sees it before its children, but siblings and separate subtrees are then
+// visited in no particular order.
+//
+// Every directory is descended into on every scan, as a change deep in the
+// tree does not show in the mtimes of the directories above it. Only
+// directories whose mtime or inode changed are read again, and only files
+// that are new to their directory's listing are handled, along with, when
+// rechecking, files modified since the last scan.
+func (s *scan) walk(workers int) {
+	root := s.p.root
+	s.lstats.Add(1)
+	info, err := os.Lstat(root)
+	if err != nil {
+		s.p.logger.Warn("Walk error", "path", root, "error", err)
+		return
+	}
+	e := &entry{
+		path:   root,
+		name:   info.Name(),
+		typ:    info.Mode().Type(),
+		lstats: &s.lstats,
+		read:   true,
+		info:   info,
+	}
+	if !e.IsDir() {
+		s.visitFile(e)
+		return
+	}
+	if !s.visitDir(e) {
+		return
+	}
+	if workers <= 1 {
+		s.walkDir(e)
+		return
+	}
+	
+	jobs := make(chan *entry, workers*4)
+	var pending sync.WaitGroup
+	var readDir func(dir *entry)
+	readDir = func(dir *entry) {
+		defer pending.Done()
+		for _, e := range s.list(dir) {
+			if !e.IsDir() {
+				s.visitFile(e)
+				continue
 			}
-		} else {
-			fileCount++
-			// Get file info
-			info, err := d.Info()
-			if err != nil {
-				p.logger.Printf("Failed to get info for %s: %v", path, err)
-				return nil
+			if !s.visitDir(e) {
+				continue
 			}
-			
-			// Skip if file hasn't been modified since last scan
-			if !lastScan.IsZero() && info.ModTime().Before(lastScan) {
-				return nil
+			pending.Add(1)
+			select {
+			case jobs <- e:
+			default:
+				// Every worker is busy and the queue is full, so
+				// walk the directory here rather than wait
+				readDir(e)
 			}
-			
-			// Process file
-			if err := p.handler(path, info); err != nil {
-				p.logger.Printf("Handler error for %s: %v", path, err)
+		}
+	}
+	
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range jobs {
+				readDir(dir)
 			}
+		}()
+	}
+	pending.Add(1)
+	jobs <- e
+	pending.Wait()
+	close(jobs)
+	wg.Wait()
+}
+
+// walkDir visits the contents of dir on the calling goroutine, in lexical
+// order
+func (s *scan) walkDir(dir *entry) {
+	for _, e := range s.list(dir) {
+		if !e.IsDir() {
+			s.visitFile(e)
+			continue
+		}
+		if s.visitDir(e) {
+			s.walkDir(e)
 		}
-		
-		return nil
-	})
+	}
+}
+
+// list returns the entries of dir, sorted by name. The previous scan's
+// listing is reused while the directory's mtime and inode are unchanged;
+// otherwise the directory is read, and entries whose type the listing does
+// not include are stat'ed for it.
+func (s *scan) list(dir *entry) []*entry {
+	p := s.p
+	s.mu.Lock()
+	s.visitedDirs[dir.path] = struct{}{}
+	s.mu.Unlock()
 	
-	// Clean up old directory entries
-	p.cleanupDirModTime(visitedDirs)
+	prev := p.listing(dir.path)
+	info, err := dir.Info()
+	if err != nil {
+		p.logger.Warn("Failed to get info for directory", "path", dir.path, "error", err)
+	}
+	if prev != nil && info != nil && prev.current(info) {
+		entries := make([]*entry, 0, len(prev.entries))
+		for _, de := range prev.entries {
+			entries = append(entries, s.entry(dir.path, de, true, false))
+		}
+		return entries
+	}
 	
-	duration := time.Since(scanStart)
-	p.logger.Printf("Scan completed in %v: %d files, %d dirs (%d skipped)", 
-		duration, fileCount, dirCount, skippedDirs)
+	s.listed.Add(1)
+	dirents, err := readDir(dir.path)
+	if err != nil {
+		// Log but continue with whatever was read
+		p.logger.Warn("Walk error", "path", dir.path, "error", err)
+	}
+	slices.SortFunc(dirents, func(a, b dirent) int { return strings.Compare(a.name, b.name) })
+	
+	var previous map[string]dirent
+	if prev != nil {
+		previous = make(map[string]dirent, len(prev.entries))
+		for _, de := range prev.entries {
+			previous[de.name] = de
+		}
+	}
+	entries := make([]*entry, 0, len(dirents))
+	listed := make([]dirent, 0, len(dirents))
+	for _, de := range dirents {
+		e := s.entry(dir.path, de, false, false)
+		if e.typ == unknownType {
+			info, err := e.Info()
+			if err != nil {
+				p.logger.Warn("Failed to get info", "path", e.path, "error", err)
+				continue
+			}
+			e.typ = info.Mode().Type()
+			de.typ = e.typ
+		}
+		if old, ok := previous[de.name]; ok && old.typ == de.typ {
+			e.known = de.ino != 0 && de.ino == old.ino
+			e.modCheck = de.ino == 0
+		}
+		entries = append(entries, e)
+		listed = append(listed, de)
+	}
 	
-	return err
+	if info != nil && err == nil {
+		p.setListing(dir.path, &listing{
+			modTime: info.ModTime(),
+			inode:   state.Inode(info),
+			listed:  s.start,
+			entries: listed,
+		})
+	}
+	return entries
 }
 
-// shouldSkipDir checks if a directory can be skipped based on modification time
-func (p *Poller) shouldSkipDir(path string, modTime time.Time) bool {
-	p.mu.RLock()
-	prevModTime, exists := p.dirModTime[path]
-	p.mu.RUnlock()
-	
-	return exists && modTime.Equal(prevModTime)
+// entry creates the entry of a listed name in dir
+func (s *scan) entry(dir string, de dirent, known, modCheck bool) *entry {
+	return &entry{
+		path:     filepath.Join(dir, de.name),
+		name:     de.name,
+		typ:      de.typ,
+		known:    known,
+		modCheck: modCheck,
+		lstats:   &s.lstats,
+	}
 }
 
-// updateDirModTime updates the modification time for a directory
-func (p *Poller) updateDirModTime(path string, modTime time.Time) {
-	p.mu.Lock()
-	p.dirModTime[path] = modTime
-	p.mu.Unlock()
+// visitDir handles a directory and reports whether to descend into it
+func (s *scan) visitDir(e *entry) bool {
+	p := s.p
+	s.dirs.Add(1)
+	
+	// Process directory first (for applying ignore attributes)
+	if err := p.handler(e.path, e); err != nil {
+		if errors.Is(err, ErrSkipDir) {
+			p.logger.Debug("Skipping contents of ignored directory", "path", e.path)
+			s.skipped.Add(1)
+			return false
+		}
+		p.logger.Warn("Handler error for directory", "path", e.path, "error", err)
+	}
+	
+	// Now check if we should skip descending into this directory
+	name := e.Name()
+	p.mu.RLock()
+	skip := p.skipDirs[name]
+	p.mu.RUnlock()
+	if skip || strings.HasPrefix(name, ".") && name != "." {
+		s.skipped.Add(1)
+		return false
+	}
+	return true
 }
 
-// cleanupDirModTime removes entries for directories that no longer exist
-func (p *Poller) cleanupDirModTime(visitedDirs map[string]struct{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// visitFile handles anything that is not a directory
+func (s *scan) visitFile(e *entry) {
+	p := s.p
+	s.files.Add(1)
+	if e.known && !s.recheck {
+		// Handled when its directory was last read
+		return
+	}
+	var d fs.DirEntry = e
+	if e.Type()&fs.ModeSymlink != 0 {
+		link, err := e.Info()
+		if err != nil {
+			p.logger.Warn("Failed to get info", "path", e.path, "error", err)
+			return
+		}
+		info, err := p.symlinks.Resolve(e.path, link)
+		if errors.Is(err, symlink.ErrEscapesRoot) {
+			p.logger.Warn("Skipping symlink that escapes the root", "path", e.path)
+			return
+		}
+		if err != nil {
+			p.logger.Debug("Skipping symlink", "path", e.path, "reason", err)
+			return
+		}
+		d = fs.FileInfoToDirEntry(info)
+	}
 	
-	// Remove entries for directories that weren't visited
-	for path := range p.dirModTime {
-		if _, visited := visitedDirs[path]; !visited {
-			delete(p.dirModTime, path)
+	// Skip if file hasn't been modified since last scan
+	if e.modCheck || e.known {
+		info, err := d.Info()
+		if err != nil {
+			p.logger.Warn("Failed to get info", "path", e.path, "error", err)
+			return
+		}
+		if info.ModTime().Before(s.lastScan) {
+			return
 		}
 	}
+	
+	// Process file, or a link to a directory, which is not
+	// descended into either way
+	if err := p.handler(e.path, d); err != nil && !errors.Is(err, ErrSkipDir) {
+		p.logger.Warn("Handler error", "path", e.path, "error", err)
+	}
 }
 
 // SetSkipDir adds or removes a directory from the skip list
@@ -257,9 +485,10 @@ func (p *Poller) GetLastScan() time.Time {
 	return p.lastScan
 }
 
-// ClearCache clears the directory modification time cache
+// ClearCache forgets the directory listings of previous scans, so that
+// the next scan reads every directory and handles every file
 func (p *Poller) ClearCache() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.dirModTime = make(map[string]time.Time)
+	p.listings = make(map[string]*listing)
 }
\ No newline at end of file
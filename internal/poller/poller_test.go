@@ -2,12 +2,17 @@ package poller
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/symlink"
 )
 
 func TestPollerBasicScan(t *testing.T) {
@@ -31,7 +36,7 @@ func TestPollerBasicScan(t *testing.T) {
 	var mu sync.Mutex
 	scanned := make(map[string]bool)
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		scanned[path] = true
 		mu.Unlock()
@@ -75,7 +80,7 @@ func TestPollerIncrementalScan(t *testing.T) {
 	var mu sync.Mutex
 	scanCount := make(map[string]int)
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		scanCount[path]++
 		mu.Unlock()
@@ -146,7 +151,7 @@ func TestPollerSkipDirectories(t *testing.T) {
 	var mu sync.Mutex
 	scanned := make(map[string]bool)
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		scanned[path] = true
 		mu.Unlock()
@@ -191,7 +196,7 @@ func TestPollerRunWithContext(t *testing.T) {
 	// Use GetLastScan to verify scan execution
 	p, err := NewPoller(Config{
 		Root:         tmpDir,
-		Handler:      func(path string, info fs.FileInfo) error { return nil },
+		Handler:      func(path string, d fs.DirEntry) error { return nil },
 		ScanInterval: 50 * time.Millisecond,
 	})
 	if err != nil {
@@ -241,7 +246,7 @@ func TestPollerDirectoryModTimeCache(t *testing.T) {
 	var mu sync.Mutex
 	calls := 0
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		calls++
 		mu.Unlock()
@@ -345,7 +350,7 @@ func TestPollerSymbolicLinks(t *testing.T) {
 	var mu sync.Mutex
 	handledPaths := make(map[string]bool)
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		handledPaths[path] = true
 		mu.Unlock()
@@ -399,6 +404,67 @@ func TestPollerSymbolicLinks(t *testing.T) {
 	t.Logf("Total paths processed: %d", len(handledPaths))
 }
 
+// TestPollerSymlinkPolicy tests which info links are handled with under
+// each policy, and that links escaping the root are left alone
+func TestPollerSymlinkPolicy(t *testing.T) {
+	outside := t.TempDir()
+	tmpDir := t.TempDir()
+	
+	realDir := filepath.Join(tmpDir, "realdir")
+	if err := os.MkdirAll(realDir, 0755); err != nil {
+		t.Fatalf("Failed to create real directory: %v", err)
+	}
+	linkDir := filepath.Join(tmpDir, "linkdir")
+	if err := os.Symlink(realDir, linkDir); err != nil {
+		t.Fatalf("Failed to create directory symlink: %v", err)
+	}
+	escape := filepath.Join(tmpDir, "escape")
+	if err := os.Symlink(outside, escape); err != nil {
+		t.Fatalf("Failed to create escaping symlink: %v", err)
+	}
+	
+	tests := []struct {
+		policy   symlink.Policy
+		expected map[string]fs.FileMode // type each link is handled with
+	}{
+		{symlink.Follow, map[string]fs.FileMode{linkDir: fs.ModeDir}},
+		{symlink.Mark, map[string]fs.FileMode{linkDir: fs.ModeSymlink, escape: fs.ModeSymlink}},
+		{symlink.Skip, map[string]fs.FileMode{}},
+	}
+	
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			handled := make(map[string]fs.FileMode)
+			p, err := NewPoller(Config{
+				Root: tmpDir,
+				Handler: func(path string, d fs.DirEntry) error {
+					if path != linkDir && path != escape {
+						return nil
+					}
+					handled[path] = d.Type()
+					// Marking a link to a directory must not stop the scan
+					return ErrSkipDir
+				},
+				Symlinks: symlink.Resolver{Policy: tt.policy, Root: tmpDir},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create poller: %v", err)
+			}
+			if err := p.Scan(); err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if len(handled) != len(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, handled)
+			}
+			for path, typ := range tt.expected {
+				if got, ok := handled[path]; !ok || got != typ {
+					t.Errorf("Expected %s to be handled as %v, got %v (handled: %v)", path, typ, got, ok)
+				}
+			}
+		})
+	}
+}
+
 // TestPollerIgnoredDirectorySkip tests that contents of ignored directories are skipped
 func TestPollerIgnoredDirectorySkip(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -437,13 +503,13 @@ func TestPollerIgnoredDirectorySkip(t *testing.T) {
 	var mu sync.Mutex
 	handledPaths := make(map[string]bool)
 	
-	handler := func(path string, info fs.FileInfo) error {
+	handler := func(path string, d fs.DirEntry) error {
 		mu.Lock()
 		handledPaths[path] = true
 		mu.Unlock()
 		
 		// Simulate ignoring the "ignored" directory
-		if path == ignoredDir && info.IsDir() {
+		if path == ignoredDir && d.IsDir() {
 			return ErrSkipDir
 		}
 		
@@ -491,4 +557,382 @@ func TestPollerIgnoredDirectorySkip(t *testing.T) {
 	}
 	
 	t.Logf("Total paths processed: %d (should be 3: root, ignored dir, and regular file)", len(handledPaths))
-}
\ No newline at end of file
+}
+
+// TestPollerOnScan tests that OnScan receives the statistics of each scan,
+// counting the skipped hidden directory
+func TestPollerOnScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, ".hidden"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(tmpDir, "sub", "b.txt"), nil, 0644)
+	
+	var stats []ScanStats
+	p, err := NewPoller(Config{
+		Root:    tmpDir,
+		Handler: func(path string, d fs.DirEntry) error { return nil },
+		OnScan:  func(s ScanStats) { stats = append(stats, s) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create poller: %v", err)
+	}
+	if err := p.Scan(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 scan, got %d", len(stats))
+	}
+	if s := stats[0]; s.Files != 2 || s.Dirs != 3 || s.Skipped != 1 {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+}
+
+// TestPollerParallelScan tests that a parallel walk visits the same paths
+// as a sequential one, each directory before its children, and honors
+// ErrSkipDir and the skip list
+func TestPollerParallelScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		dir := filepath.Join(tmpDir, fmt.Sprintf("dir%02d", i), "nested", "deeper")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		for j := 0; j < 5; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", j)), []byte("x"), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+		}
+	}
+	os.MkdirAll(filepath.Join(tmpDir, "node_modules", "pkg"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "ignored", "child"), 0755)
+	
+	walk := func(workers int) ([]string, ScanStats) {
+		var (
+			mu    sync.Mutex
+			order []string
+			stats ScanStats
+		)
+		p, err := NewPoller(Config{
+			Root: tmpDir,
+			Handler: func(path string, d fs.DirEntry) error {
+				mu.Lock()
+				order = append(order, path)
+				mu.Unlock()
+				if filepath.Base(path) == "ignored" {
+					return ErrSkipDir
+				}
+				return nil
+			},
+			OnScan:  func(s ScanStats) { stats = s },
+			Workers: workers,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create poller: %v", err)
+		}
+		if err := p.Scan(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		return order, stats
+	}
+	
+	sequential, seqStats := walk(1)
+	parallel, parStats := walk(8)
+	
+	if seqStats.Files != parStats.Files || seqStats.Dirs != parStats.Dirs || seqStats.Skipped != parStats.Skipped {
+		t.Errorf("Stats differ: sequential %+v, parallel %+v", seqStats, parStats)
+	}
+	seen := make(map[string]int, len(parallel))
+	for i, path := range parallel {
+		seen[path] = i
+	}
+	if len(seen) != len(sequential) {
+		t.Errorf("Expected %d paths, got %d", len(sequential), len(seen))
+	}
+	for _, path := range sequential {
+		if _, ok := seen[path]; !ok {
+			t.Errorf("Path not visited by the parallel walk: %s", path)
+		}
+	}
+	for path, i := range seen {
+		if path == tmpDir {
+			continue
+		}
+		if parent, ok := seen[filepath.Dir(path)]; !ok || parent > i {
+			t.Errorf("Visited %s before its directory", path)
+		}
+	}
+	for _, unexpected := range []string{
+		filepath.Join(tmpDir, "ignored", "child"),
+		filepath.Join(tmpDir, "node_modules", "pkg"),
+	} {
+		if _, ok := seen[unexpected]; ok {
+			t.Errorf("Expected %s to be skipped", unexpected)
+		}
+	}
+}
+
+// TestPollerLstats tests that a first scan reads no file metadata unless
+// the handler asks for it
+func TestPollerLstats(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(tmpDir, "sub", "b.txt"), []byte("data"), 0644)
+	
+	for _, workers := range []int{1, 4} {
+		var stats ScanStats
+		sizes := make(map[string]int64)
+		var mu sync.Mutex
+		p, err := NewPoller(Config{
+			Root: tmpDir,
+			Handler: func(path string, d fs.DirEntry) error {
+				if filepath.Base(path) != "b.txt" {
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				sizes[path] = info.Size()
+				mu.Unlock()
+				return nil
+			},
+			OnScan:  func(s ScanStats) { stats = s },
+			Workers: workers,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create poller: %v", err)
+		}
+		if err := p.Scan(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		// The directories, whose listings are recorded, and the one
+		// file the handler asked about
+		if stats.Lstats != 3 {
+			t.Errorf("Expected 3 lstats with %d workers, got %+v", workers, stats)
+		}
+		if sizes[filepath.Join(tmpDir, "sub", "b.txt")] != 4 {
+			t.Errorf("Expected the handler to read the size, got %v", sizes)
+		}
+	}
+}
+
+// TestPollerDeepChanges tests that incremental scans find entries created
+// below directories whose own mtime did not change, and files replaced
+// under the same name, without reading unchanged directories again
+func TestPollerDeepChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	deep := filepath.Join(tmpDir, "a", "b")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	other := filepath.Join(tmpDir, "other")
+	if err := os.MkdirAll(other, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	replaced := filepath.Join(other, "replaced.txt")
+	if err := os.WriteFile(replaced, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	// Age the directories past the window in which their listings
+	// cannot be trusted
+	past := time.Now().Add(-time.Hour)
+	age := func(dirs ...string) {
+		for _, dir := range dirs {
+			if err := os.Chtimes(dir, past, past); err != nil {
+				t.Fatalf("Failed to age %s: %v", dir, err)
+			}
+		}
+	}
+	age(tmpDir, filepath.Join(tmpDir, "a"), deep, other)
+	
+	var (
+		mu      sync.Mutex
+		handled []string
+		stats   ScanStats
+	)
+	p, err := NewPoller(Config{
+		Root: tmpDir,
+		Handler: func(path string, d fs.DirEntry) error {
+			if !d.IsDir() {
+				mu.Lock()
+				handled = append(handled, path)
+				mu.Unlock()
+			}
+			return nil
+		},
+		OnScan: func(s ScanStats) { stats = s },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create poller: %v", err)
+	}
+	if err := p.Scan(); err != nil {
+		t.Fatalf("First scan failed: %v", err)
+	}
+	
+	// Nothing changed: every directory is checked, none is read
+	handled = nil
+	if err := p.Scan(); err != nil {
+		t.Fatalf("Second scan failed: %v", err)
+	}
+	if len(handled) != 0 || stats.Listed != 0 || stats.Dirs != 4 || stats.Files != 1 {
+		t.Errorf("Expected an unchanged tree not to be read, handled %v, stats %+v", handled, stats)
+	}
+	if stats.Lstats != 4 {
+		t.Errorf("Expected only the directories to be stat'ed, got %+v", stats)
+	}
+	
+	// A build directory two levels down and a file replaced by rename
+	build := filepath.Join(deep, "build")
+	if err := os.Mkdir(build, 0755); err != nil {
+		t.Fatalf("Failed to create build directory: %v", err)
+	}
+	output := filepath.Join(build, "output.o")
+	if err := os.WriteFile(output, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	tmp := filepath.Join(tmpDir, "replacement")
+	if err := os.WriteFile(tmp, []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Rename(tmp, replaced); err != nil {
+		t.Fatalf("Failed to replace file: %v", err)
+	}
+	age(tmpDir, filepath.Join(tmpDir, "a"))
+	
+	handled = nil
+	if err := p.Scan(); err != nil {
+		t.Fatalf("Third scan failed: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, path := range handled {
+		found[path] = true
+	}
+	if !found[output] {
+		t.Errorf("Expected the new file below unchanged directories to be handled, got %v", handled)
+	}
+	if runtime.GOOS == "linux" && !found[replaced] {
+		t.Errorf("Expected the replaced file to be handled, got %v", handled)
+	}
+	// b, build and other changed; the root and a did not
+	if stats.Listed != 3 {
+		t.Errorf("Expected 3 directories to be read, got %+v", stats)
+	}
+}
+
+// TestPollerRecheckModified tests that files written in place, which
+// leaves their directory unchanged, are handled again only when rechecking
+func TestPollerRecheckModified(t *testing.T) {
+	for _, recheck := range []bool{false, true} {
+		t.Run(fmt.Sprint(recheck), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			file := filepath.Join(tmpDir, "grows.bin")
+			if err := os.WriteFile(file, []byte("small"), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+			past := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(file, past, past); err != nil {
+				t.Fatalf("Failed to age file: %v", err)
+			}
+			if err := os.Chtimes(tmpDir, past, past); err != nil {
+				t.Fatalf("Failed to age directory: %v", err)
+			}
+			
+			var sizes []int64
+			p, err := NewPoller(Config{
+				Root: tmpDir,
+				Handler: func(path string, d fs.DirEntry) error {
+					if path != file {
+						return nil
+					}
+					info, err := d.Info()
+					if err != nil {
+						return err
+					}
+					sizes = append(sizes, info.Size())
+					return nil
+				},
+				RecheckModified: func() bool { return recheck },
+			})
+			if err != nil {
+				t.Fatalf("Failed to create poller: %v", err)
+			}
+			if err := p.Scan(); err != nil {
+				t.Fatalf("First scan failed: %v", err)
+			}
+			// Unchanged, so not handled again either way
+			if err := p.Scan(); err != nil {
+				t.Fatalf("Second scan failed: %v", err)
+			}
+			
+			time.Sleep(10 * time.Millisecond)
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatalf("Failed to open file: %v", err)
+			}
+			f.Write(make([]byte, 1000))
+			f.Close()
+			if info, _ := os.Stat(tmpDir); !info.ModTime().Equal(past) {
+				t.Fatalf("Expected an in-place write to leave the directory unchanged")
+			}
+			if err := p.Scan(); err != nil {
+				t.Fatalf("Third scan failed: %v", err)
+			}
+			
+			expected := []int64{5}
+			if recheck {
+				expected = append(expected, 1005)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(expected) {
+				t.Errorf("Expected the file to be handled with sizes %v, got %v", expected, sizes)
+			}
+		})
+	}
+}
+
+// BenchmarkScan compares the metadata reads of a first scan whose handler
+// decides on names and types alone with one that reads every entry's info,
+// as matching used to
+func BenchmarkScan(b *testing.B) {
+	root := b.TempDir()
+	for i := 0; i < 50; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 200; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d.txt", j)), nil, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	
+	handlers := map[string]Handler{
+		"names": func(path string, d fs.DirEntry) error { return nil },
+		"info": func(path string, d fs.DirEntry) error {
+			_, err := d.Info()
+			return err
+		},
+	}
+	for _, name := range []string{"names", "info"} {
+		b.Run(name, func(b *testing.B) {
+			var lstats int
+			for i := 0; i < b.N; i++ {
+				p, err := NewPoller(Config{
+					Root:    root,
+					Handler: handlers[name],
+					Logger:  slog.New(slog.DiscardHandler),
+					OnScan:  func(s ScanStats) { lstats += s.Lstats },
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				p.Scan()
+			}
+			b.ReportMetric(float64(lstats)/float64(b.N), "lstats/op")
+		})
+	}
+}
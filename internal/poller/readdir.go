@@ -0,0 +1,56 @@
+package poller
+
+import (
+	"io/fs"
+	"os"
+	"sync/atomic"
+)
+
+// unknownType marks listed entries whose type the filesystem did not
+// report
+const unknownType = ^fs.FileMode(0)
+
+// dirent is a name and type as read from a directory listing
+type dirent struct {
+	name string
+	typ  fs.FileMode
+	// ino is the inode number, or 0 where listings do not include it
+	ino uint64
+}
+
+// entry is a directory entry whose metadata is read with lstat on first
+// use and kept. An entry is only used by the goroutine that listed it.
+type entry struct {
+	path string
+	name string
+	typ  fs.FileMode
+	// known is set for files the previous scan listed with the same
+	// inode, which were handled then and are only handled again if
+	// modified since, when the scan rechecks
+	known bool
+	// modCheck is set for files the previous scan listed under the same
+	// name but without an inode to tell whether they were replaced. They
+	// are handled only if modified since.
+	modCheck bool
+	// lstats counts the metadata reads of the scan
+	lstats *atomic.Int64
+
+	read bool
+	info fs.FileInfo
+	err  error
+}
+
+func (e *entry) Name() string      { return e.name }
+func (e *entry) IsDir() bool       { return e.typ.IsDir() }
+func (e *entry) Type() fs.FileMode { return e.typ }
+func (e *entry) String() string    { return fs.FormatDirEntry(e) }
+
+// Info returns the entry's metadata, reading it on the first call
+func (e *entry) Info() (fs.FileInfo, error) {
+	if !e.read {
+		e.read = true
+		e.lstats.Add(1)
+		e.info, e.err = os.Lstat(e.path)
+	}
+	return e.info, e.err
+}
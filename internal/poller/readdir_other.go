@@ -0,0 +1,17 @@
+// +build !linux
+
+package poller
+
+import "os"
+
+// readDir lists dir with os.ReadDir, which reports entry types without
+// stat calls where the platform allows. Inodes are left out, as reading
+// them would take a stat per entry.
+func readDir(dir string) ([]dirent, error) {
+	entries, err := os.ReadDir(dir)
+	dirents := make([]dirent, 0, len(entries))
+	for _, e := range entries {
+		dirents = append(dirents, dirent{name: e.Name(), typ: e.Type()})
+	}
+	return dirents, err
+}
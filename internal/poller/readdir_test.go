@@ -0,0 +1,75 @@
+package poller
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadDir(t *testing.T) {
+	dir := t.TempDir()
+	// Enough long names to take several reads of the listing
+	for i := 0; i < 3000; i++ {
+		name := fmt.Sprintf("%04d-%s", i, strings.Repeat("x", 60))
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dirents, err := readDir(dir)
+	if err != nil {
+		t.Fatalf("readDir failed: %v", err)
+	}
+	expected, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]fs.FileMode, len(dirents))
+	for _, de := range dirents {
+		got[de.name] = de.typ
+	}
+	if len(got) != len(dirents) || len(got) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d (%d unique)", len(expected), len(dirents), len(got))
+	}
+	for _, e := range expected {
+		if typ, ok := got[e.Name()]; !ok || typ != e.Type() {
+			t.Errorf("Expected %s with type %v, got %v (listed: %v)", e.Name(), e.Type(), typ, ok)
+		}
+	}
+
+	if _, err := readDir(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing directory to fail with ErrNotExist, got %v", err)
+	}
+}
+
+func TestEntryInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var lstats atomic.Int64
+	e := &entry{path: path, name: "file", lstats: &lstats}
+	if e.IsDir() || e.Type() != 0 || lstats.Load() != 0 {
+		t.Errorf("Expected the type without reading metadata, got %v after %d lstats", e.Type(), lstats.Load())
+	}
+	for i := 0; i < 3; i++ {
+		info, err := e.Info()
+		if err != nil || info.Size() != 4 {
+			t.Fatalf("Unexpected info %v, %v", info, err)
+		}
+	}
+	if lstats.Load() != 1 {
+		t.Errorf("Expected the metadata to be read once, got %d", lstats.Load())
+	}
+}
@@ -0,0 +1,18 @@
+// +build darwin
+
+package report
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the inode change time, which setting the ignore
+// attribute updates
+func changeTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec)
+	}
+	return info.ModTime()
+}
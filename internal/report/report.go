@@ -0,0 +1,313 @@
+// Package report lists the paths currently ignored by Dropbox under a root
+// along with their size, the rule responsible and when they were marked.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/audit"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/xattr"
+)
+
+// Where an entry's Marked time comes from
+const (
+	// MarkedAudit is the time the audit log recorded the mark
+	MarkedAudit = "audit"
+	// MarkedCtime is the inode change time, used when the audit log has no
+	// record of the mark. Later permission or ownership changes update it
+	// too, so it is only an upper bound.
+	MarkedCtime = "ctime"
+)
+
+// Entry is an ignored path
+type Entry struct {
+	Path string `json:"path"`
+	Dir  bool   `json:"dir"`
+	// Size and Files cover a directory's contents
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+	// Rule is the rule that ignores the path now, empty when none does,
+	// for example because the attribute was set by hand
+	Rule string `json:"rule,omitempty"`
+	// Marked is when the attribute was set, as far as known
+	Marked time.Time `json:"marked"`
+	// MarkedFrom is MarkedAudit or MarkedCtime
+	MarkedFrom string `json:"marked_from"`
+}
+
+// Report lists ignored paths, largest first
+type Report struct {
+	Root       string    `json:"root"`
+	Generated  time.Time `json:"generated"`
+	TotalSize  int64     `json:"total_size"`
+	TotalFiles int       `json:"total_files"`
+	Entries    []Entry   `json:"entries"`
+}
+
+// Config holds report configuration
+type Config struct {
+	Root    string
+	Matcher *matcher.Matcher
+	// AuditLog, if set, is the daemon's audit log, which the time of each
+	// mark is taken from
+	AuditLog string
+	// IsIgnored reports whether a path carries the ignore attribute.
+	// Defaults to xattr.IsIgnored.
+	IsIgnored func(path string) (bool, error)
+	Logger    *slog.Logger
+}
+
+// Collect walks the whole tree under the root and lists every ignored
+// path. Unlike the poller it also enters hidden directories and
+// node_modules, since attributes set by hand can be anywhere. Ignored
+// directories are listed once and walked separately for sizing.
+func Collect(cfg Config) (*Report, error) {
+	if cfg.IsIgnored == nil {
+		cfg.IsIgnored = xattr.IsIgnored
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	marks := make(map[string]audit.Record)
+	if cfg.AuditLog != "" {
+		records, err := audit.ReadAll(cfg.AuditLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		// The last real change per path; dry runs changed nothing
+		for _, rec := range records {
+			if !rec.DryRun {
+				marks[rec.Path] = rec
+			}
+		}
+	}
+
+	r := &Report{Root: cfg.Root, Generated: time.Now()}
+	err := filepath.WalkDir(cfg.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == cfg.Root {
+				return err
+			}
+			cfg.Logger.Warn("Failed to read", "path", path, "error", err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == cfg.Root {
+			return nil
+		}
+
+		ignored, err := cfg.IsIgnored(path)
+		if err != nil {
+			cfg.Logger.Warn("Failed to check xattr", "path", path, "error", err)
+			return nil
+		}
+		if !ignored {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			cfg.Logger.Warn("Failed to get info", "path", path, "error", err)
+			return nil
+		}
+
+		e := Entry{Path: path, Dir: info.IsDir(), Marked: changeTime(info), MarkedFrom: MarkedCtime}
+		// A mark recorded for another file at the same path does not count
+		if rec, ok := marks[path]; ok && rec.Action == audit.ActionSet && markedSame(rec) {
+			e.Marked, e.MarkedFrom = rec.Time, MarkedAudit
+		}
+		if info.IsDir() {
+			e.Size, e.Files = matcher.DirSize(path)
+		} else if info.Mode().IsRegular() {
+			e.Size, e.Files = info.Size(), 1
+		}
+		if cfg.Matcher != nil {
+			if res, err := cfg.Matcher.Match(path, d); err == nil && res.Ignored {
+				e.Rule = res.Describe()
+			}
+		}
+		r.Entries = append(r.Entries, e)
+		r.TotalSize += e.Size
+		r.TotalFiles += e.Files
+
+		// The contents are already covered by the directory's entry
+		if info.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(r.Entries, func(i, j int) bool {
+		if r.Entries[i].Size != r.Entries[j].Size {
+			return r.Entries[i].Size > r.Entries[j].Size
+		}
+		return r.Entries[i].Path < r.Entries[j].Path
+	})
+	return r, nil
+}
+
+// Formats accepted by Write
+var Formats = []string{"table", "json", "csv", "html"}
+
+// Write renders the report in one of Formats
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return r.writeTable(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "csv":
+		return r.writeCSV(w)
+	case "html":
+		return htmlTemplate.Execute(w, r)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tFILES\tMARKED\tRULE\tPATH")
+	for _, e := range r.Entries {
+		rule := e.Rule
+		if rule == "" {
+			rule = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", FormatBytes(e.Size), e.Files, formatMarked(e), rule, e.Path)
+	}
+	fmt.Fprintf(tw, "%s\t%d\t\t\ttotal (%d paths)\n", FormatBytes(r.TotalSize), r.TotalFiles, len(r.Entries))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if r.hasCtime() {
+		_, err := fmt.Fprintln(w, ctimeNote)
+		return err
+	}
+	return nil
+}
+
+// ctimeNote explains entries whose marked time is the inode change time
+const ctimeNote = "(ctime): no audit log record of the mark, shown is the inode change time, which later changes also update"
+
+// hasCtime reports whether any entry's marked time is the inode change time
+func (r *Report) hasCtime() bool {
+	for _, e := range r.Entries {
+		if e.MarkedFrom == MarkedCtime {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "dir", "size", "files", "rule", "marked", "marked_from"})
+	for _, e := range r.Entries {
+		cw.Write([]string{
+			e.Path,
+			strconv.FormatBool(e.Dir),
+			strconv.FormatInt(e.Size, 10),
+			strconv.Itoa(e.Files),
+			e.Rule,
+			e.Marked.Format(time.RFC3339),
+			e.MarkedFrom,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// markedSame reports whether a mark was recorded for what is at its path
+// now, comparing with the target of a followed link like the mark did
+func markedSame(rec audit.Record) bool {
+	if rec.Inode == 0 {
+		return true
+	}
+	info, err := rec.Stat()
+	return err == nil && state.Inode(info) == rec.Inode
+}
+
+// formatMarked formats the time an entry was marked, labelling inode
+// change times, which are only an upper bound
+func formatMarked(e Entry) string {
+	marked := e.Marked.Format("2006-01-02 15:04")
+	if e.MarkedFrom == MarkedCtime {
+		marked += " (ctime)"
+	}
+	return marked
+}
+
+// FormatBytes formats a size with a binary unit
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// htmlTemplate renders a self-contained page without external assets
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":     FormatBytes,
+	"date":      func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"marked":    formatMarked,
+	"hasCtime":  (*Report).hasCtime,
+	"ctimeNote": func() string { return ctimeNote },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Ignored content under {{.Root}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2em; color: #1e1919; }
+h1 { font-size: 1.4em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+th { background: #f7f5f2; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; white-space: nowrap; }
+td.path { font-family: ui-monospace, Menlo, monospace; word-break: break-all; }
+tfoot td { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Ignored content under {{.Root}}</h1>
+<p>{{len .Entries}} paths, {{.TotalFiles}} files, {{bytes .TotalSize}} kept out of Dropbox. Generated {{date .Generated}}.</p>
+<table>
+<thead><tr><th>Size</th><th>Files</th><th>Marked</th><th>Rule</th><th>Path</th></tr></thead>
+<tbody>
+{{- range .Entries}}
+<tr><td class="num">{{bytes .Size}}</td><td class="num">{{.Files}}</td><td class="num">{{marked .}}</td><td>{{if .Rule}}{{.Rule}}{{else}}-{{end}}</td><td class="path">{{.Path}}{{if .Dir}}/{{end}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot><tr><td class="num">{{bytes .TotalSize}}</td><td class="num">{{.TotalFiles}}</td><td></td><td></td><td>Total</td></tr></tfoot>
+</table>
+{{- if hasCtime .}}
+<p>{{ctimeNote}}</p>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,176 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/audit"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+)
+
+func TestCollect(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{
+		".dropboxignore":          0,
+		"node_modules/a/index.js": 3000,
+		"node_modules/b/index.js": 1000,
+		"notes.txt":               10,
+		"debug.log":               500,
+		"manual.bin":              20,
+		".cache/blob":             30,
+		"web/node_modules/x.js":   40,
+	}
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, ".dropboxignore"), []byte("node_modules/\n*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	// Stand in for the attribute, which tmpfs may not support
+	marked := map[string]bool{
+		filepath.Join(root, "node_modules"): true,
+		filepath.Join(root, "debug.log"):    true,
+		filepath.Join(root, "manual.bin"):   true,
+		// Marked by hand where the poller does not look
+		filepath.Join(root, ".cache"):                true,
+		filepath.Join(root, "web/node_modules/x.js"): true,
+	}
+
+	// The audit log knows when debug.log was marked, and has a stale mark
+	// for manual.bin from a file since replaced
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(audit.Config{Path: logPath})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	markedAt := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	info, _ := os.Lstat(filepath.Join(root, "debug.log"))
+	l.Write(audit.Record{Time: markedAt, Path: filepath.Join(root, "debug.log"), Inode: state.Inode(info), Action: audit.ActionSet})
+	info, _ = os.Lstat(filepath.Join(root, "manual.bin"))
+	l.Write(audit.Record{Time: markedAt, Path: filepath.Join(root, "manual.bin"), Inode: state.Inode(info) + 1, Action: audit.ActionSet})
+	l.Close()
+
+	m, err := matcher.NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	r, err := Collect(Config{
+		Root:      root,
+		Matcher:   m,
+		AuditLog:  logPath,
+		IsIgnored: func(path string) (bool, error) { return marked[path], nil },
+	})
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	ignoreFile := filepath.Join(root, ".dropboxignore")
+	expected := []Entry{
+		{Path: filepath.Join(root, "node_modules"), Dir: true, Size: 4000, Files: 2, Rule: ignoreFile + ":1: node_modules/", MarkedFrom: MarkedCtime},
+		{Path: filepath.Join(root, "debug.log"), Size: 500, Files: 1, Rule: ignoreFile + ":2: *.log", Marked: markedAt, MarkedFrom: MarkedAudit},
+		{Path: filepath.Join(root, "web/node_modules/x.js"), Size: 40, Files: 1, Rule: ignoreFile + ":1: node_modules/", MarkedFrom: MarkedCtime},
+		{Path: filepath.Join(root, ".cache"), Dir: true, Size: 30, Files: 1, MarkedFrom: MarkedCtime},
+		{Path: filepath.Join(root, "manual.bin"), Size: 20, Files: 1, MarkedFrom: MarkedCtime},
+	}
+	if len(r.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), r.Entries)
+	}
+	for i, e := range r.Entries {
+		if e.Marked.IsZero() {
+			t.Errorf("Entry %s has no marked time", e.Path)
+		}
+		if e.MarkedFrom == MarkedCtime {
+			e.Marked = expected[i].Marked
+		} else if !e.Marked.Equal(expected[i].Marked) {
+			t.Errorf("Entry %s: expected the audit log time %v, got %v", e.Path, expected[i].Marked, e.Marked)
+		}
+		e.Marked = expected[i].Marked
+		if e != expected[i] {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, expected[i], e)
+		}
+	}
+	if r.TotalSize != 4590 || r.TotalFiles != 6 {
+		t.Errorf("Expected totals 4590 bytes in 6 files, got %d in %d", r.TotalSize, r.TotalFiles)
+	}
+
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, format); err != nil {
+			t.Errorf("Write(%s) failed: %v", format, err)
+			continue
+		}
+		out := buf.String()
+		switch format {
+		case "json":
+			var decoded Report
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Entries) != 5 {
+				t.Errorf("Invalid JSON report: %v", err)
+			}
+		case "csv":
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil || len(records) != 6 || records[1][2] != "4000" || records[2][6] != MarkedAudit {
+				t.Errorf("Invalid CSV report: %v %v", err, records)
+			}
+		case "html":
+			if !strings.Contains(out, "<table>") || !strings.Contains(out, "3.9 KiB") {
+				t.Errorf("Invalid HTML report: %s", out)
+			}
+		case "table":
+			if !strings.Contains(out, "total (5 paths)") || !strings.Contains(out, "(ctime)") {
+				t.Errorf("Invalid table report: %s", out)
+			}
+		}
+	}
+	if err := r.Write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestCollectFollowedLink(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "data.bin")
+	link := filepath.Join(root, "link.bin")
+	if err := os.WriteFile(target, make([]byte, 10), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	// Under the follow policy the daemon records the target's inode
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(audit.Config{Path: logPath})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	markedAt := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	info, _ := os.Stat(target)
+	l.Write(audit.Record{Time: markedAt, Path: link, Inode: state.Inode(info), Action: audit.ActionSet})
+	l.Close()
+
+	r, err := Collect(Config{
+		Root:      root,
+		AuditLog:  logPath,
+		IsIgnored: func(path string) (bool, error) { return path == link, nil },
+	})
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if len(r.Entries) != 1 || r.Entries[0].MarkedFrom != MarkedAudit || !r.Entries[0].Marked.Equal(markedAt) {
+		t.Errorf("Expected the link's mark time from the audit log, got %+v", r.Entries)
+	}
+}
@@ -0,0 +1,345 @@
+// Package retry keeps a persistent queue of paths whose ignore attribute
+// could not be written because of a transient error, and retries them with
+// exponential backoff.
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Default delay before the first retry, doubled after each attempt
+	defaultInitialBackoff = 5 * time.Second
+	// Default upper bound of the delay between retries
+	defaultMaxBackoff = time.Hour
+	// Default number of attempts, counting the original failure, before a
+	// path is given up on
+	defaultMaxAttempts = 10
+	// Version of the queue file format
+	fileVersion = 1
+)
+
+// Entry is a path waiting to be retried
+type Entry struct {
+	Path     string `json:"path"`
+	Attempts int    `json:"attempts"`
+	// LastError is the error of the most recent attempt
+	LastError    string    `json:"last_error"`
+	FirstFailure time.Time `json:"first_failure"`
+	// NextAttempt is when the path is retried; zero once given up on
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	// Failed is set once the path has used up its attempts or failed
+	// with an error that is not transient
+	Failed bool `json:"failed,omitempty"`
+}
+
+// file is the on-disk form of the queue
+type file struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// Config holds retry queue configuration
+type Config struct {
+	// Path is where the queue is persisted; empty keeps it in memory
+	Path string
+	// InitialBackoff is the delay before the first retry. Defaults to 5s.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 1h.
+	MaxBackoff time.Duration
+	// MaxAttempts is how many attempts, counting the original failure, a
+	// path gets before it is given up on. Defaults to 10.
+	MaxAttempts int
+	Logger      *slog.Logger
+}
+
+// Queue is a retry queue, safe for concurrent use
+type Queue struct {
+	config  Config
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// wake interrupts Run's wait when an entry is added
+	wake chan struct{}
+	now  func() time.Time
+}
+
+// NewQueue creates a queue, loading the entries persisted at cfg.Path
+func NewQueue(cfg Config) (*Queue, error) {
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	q := &Queue{
+		config:  cfg,
+		entries: make(map[string]*Entry),
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+	if cfg.Path != "" {
+		entries, err := Load(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			q.entries[entries[i].Path] = &entries[i]
+		}
+	}
+	return q, nil
+}
+
+// Transient reports whether err is worth retrying: the file was busy,
+// locked or the operation was interrupted
+func Transient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETXTBSY,
+		syscall.ENOLCK, syscall.EDEADLK, syscall.ETIMEDOUT:
+		return true
+	}
+	return false
+}
+
+// Add records a failed attempt for a path. Transient errors schedule a
+// retry with backoff; other errors, or running out of attempts, mark the
+// path as failed.
+func (q *Queue) Add(path string, err error) {
+	q.mu.Lock()
+	e, ok := q.entries[path]
+	if !ok {
+		e = &Entry{Path: path, FirstFailure: q.now()}
+		q.entries[path] = e
+	}
+	q.fail(e, err)
+	q.persist()
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail counts an attempt and reschedules or gives up on the entry. The
+// caller holds q.mu.
+func (q *Queue) fail(e *Entry, err error) {
+	e.Attempts++
+	e.LastError = err.Error()
+	if !Transient(err) || e.Attempts >= q.config.MaxAttempts {
+		if !e.Failed {
+			q.config.Logger.Warn("Giving up on path", "path", e.Path, "attempts", e.Attempts, "error", err)
+		}
+		e.Failed = true
+		e.NextAttempt = time.Time{}
+		return
+	}
+	e.Failed = false
+	e.NextAttempt = q.now().Add(q.backoff(e.Attempts))
+}
+
+// backoff is the delay after the given number of attempts
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.config.InitialBackoff
+	for i := 1; i < attempts && d < q.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.config.MaxBackoff)
+}
+
+// Remove drops a path from the queue, such as once its attribute was set
+// by a scan
+func (q *Queue) Remove(path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[path]; ok {
+		delete(q.entries, path)
+		q.persist()
+	}
+}
+
+// Entries returns the queued paths, sorted by path
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, *e)
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// Len returns the number of paths waiting to be retried and the number
+// given up on
+func (q *Queue) Len() (pending, failed int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		if e.Failed {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	return pending, failed
+}
+
+// Run retries due paths with retry, which writes the attribute of a path
+// again, until ctx is done
+func (q *Queue) Run(ctx context.Context, retry func(path string) error) error {
+	for {
+		q.retryDue(retry)
+
+		timer := time.NewTimer(q.untilNext())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// untilNext is how long until the next retry is due
+func (q *Queue) untilNext() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	next := time.Duration(-1)
+	for _, e := range q.entries {
+		if e.Failed {
+			continue
+		}
+		if d := e.NextAttempt.Sub(q.now()); next < 0 || d < next {
+			next = d
+		}
+	}
+	if next < 0 {
+		// Nothing is pending; wait to be woken by Add
+		return q.config.MaxBackoff
+	}
+	return max(next, 0)
+}
+
+// retryDue retries every path whose next attempt is due
+func (q *Queue) retryDue(retry func(path string) error) {
+	q.mu.Lock()
+	var due []string
+	for path, e := range q.entries {
+		if !e.Failed && !e.NextAttempt.After(q.now()) {
+			due = append(due, path)
+		}
+	}
+	q.mu.Unlock()
+	sort.Strings(due)
+
+	for _, path := range due {
+		err := retry(path)
+
+		q.mu.Lock()
+		e, ok := q.entries[path]
+		switch {
+		case !ok:
+			// Removed while retrying
+		case err == nil:
+			q.config.Logger.Info("Retry succeeded", "path", path, "attempts", e.Attempts+1)
+			delete(q.entries, path)
+		case errors.Is(err, fs.ErrNotExist):
+			q.config.Logger.Debug("Dropping retry of removed path", "path", path)
+			delete(q.entries, path)
+		default:
+			q.config.Logger.Debug("Retry failed", "path", path, "attempts", e.Attempts+1, "error", err)
+			q.fail(e, err)
+		}
+		q.persist()
+		q.mu.Unlock()
+	}
+}
+
+// persist writes the queue to its file. The caller holds q.mu.
+func (q *Queue) persist() {
+	if q.config.Path == "" {
+		return
+	}
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, *e)
+	}
+	sortEntries(entries)
+	if err := save(q.config.Path, entries); err != nil {
+		q.config.Logger.Error("Failed to save retry queue", "path", q.config.Path, "error", err)
+	}
+}
+
+// save writes entries to a temporary file and renames it over path, so
+// that a crash never leaves a truncated queue
+func save(path string, entries []Entry) error {
+	data, err := json.MarshalIndent(file{Version: fileVersion, Entries: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the entries persisted at path. A missing file is an empty
+// queue.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse retry queue %s: %w", path, err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported retry queue version %d in %s", f.Version, path)
+	}
+	return f.Entries, nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+}
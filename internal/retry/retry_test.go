@@ -0,0 +1,189 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTransient(t *testing.T) {
+	for _, tt := range []struct {
+		err      error
+		expected bool
+	}{
+		{syscall.EBUSY, true},
+		{fmt.Errorf("setxattr: %w", syscall.EAGAIN), true},
+		{&os.PathError{Op: "setxattr", Path: "/x", Err: syscall.ETXTBSY}, true},
+		{syscall.EACCES, false},
+		{syscall.ENOENT, false},
+		{fmt.Errorf("plain"), false},
+	} {
+		if got := Transient(tt.err); got != tt.expected {
+			t.Errorf("Transient(%v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q, err := NewQueue(Config{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	for attempts, expected := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		60: 10 * time.Second,
+	} {
+		if got := q.backoff(attempts); got != expected {
+			t.Errorf("backoff(%d) = %v, expected %v", attempts, got, expected)
+		}
+	}
+}
+
+func TestAddPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "retry.json")
+	q, err := NewQueue(Config{
+		Path:        path,
+		MaxAttempts: 2,
+		Logger:      slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	q.now = func() time.Time { return now }
+
+	q.Add("/busy", syscall.EBUSY)
+	q.Add("/denied", syscall.EACCES)
+	q.Add("/twice", syscall.EAGAIN)
+	q.Add("/twice", syscall.EAGAIN)
+
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v", entries)
+	}
+	busy, denied, twice := entries[0], entries[1], entries[2]
+	if busy.Path != "/busy" || busy.Failed || busy.Attempts != 1 ||
+		!busy.NextAttempt.Equal(now.Add(defaultInitialBackoff)) {
+		t.Errorf("Unexpected entry %+v", busy)
+	}
+	if !denied.Failed || !denied.NextAttempt.IsZero() || denied.LastError != syscall.EACCES.Error() {
+		t.Errorf("Expected a non-transient error to give up, got %+v", denied)
+	}
+	if !twice.Failed || twice.Attempts != 2 {
+		t.Errorf("Expected the path to be given up after 2 attempts, got %+v", twice)
+	}
+
+	// A new queue picks up where the old one left off
+	reloaded, err := NewQueue(Config{Path: path})
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	if pending, failed := reloaded.Len(); pending != 1 || failed != 2 {
+		t.Errorf("Expected 1 pending and 2 failed, got %d and %d", pending, failed)
+	}
+
+	reloaded.Remove("/busy")
+	entries, _ = Load(path)
+	if len(entries) != 2 {
+		t.Errorf("Expected the removal to be persisted, got %+v", entries)
+	}
+}
+
+func TestRetryDue(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		tries = map[string]int{}
+	)
+	results := map[string][]error{
+		"/recovers": {syscall.EBUSY, nil},
+		"/gone":     {os.ErrNotExist},
+		"/stuck":    {syscall.EBUSY, syscall.EBUSY, syscall.EBUSY},
+	}
+	retry := func(path string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		err := results[path][tries[path]]
+		tries[path]++
+		return err
+	}
+	q, err := NewQueue(Config{MaxAttempts: 3, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	now := time.Unix(0, 0)
+	q.now = func() time.Time { return now }
+
+	for path := range results {
+		q.Add(path, syscall.EBUSY)
+	}
+	// Nothing is due yet
+	q.retryDue(retry)
+	if len(tries) != 0 {
+		t.Fatalf("Expected no retries before the backoff, got %v", tries)
+	}
+
+	now = now.Add(time.Hour)
+	q.retryDue(retry)
+	now = now.Add(time.Hour)
+	q.retryDue(retry)
+	now = now.Add(time.Hour)
+	q.retryDue(retry)
+
+	entries := q.Entries()
+	if len(entries) != 1 || entries[0].Path != "/stuck" || !entries[0].Failed || entries[0].Attempts != 3 {
+		t.Errorf("Expected only /stuck to remain as failed, got %+v", entries)
+	}
+	if tries["/recovers"] != 2 || tries["/gone"] != 1 || tries["/stuck"] != 2 {
+		t.Errorf("Unexpected retries %v", tries)
+	}
+}
+
+func TestRun(t *testing.T) {
+	done := make(chan string, 1)
+	q, err := NewQueue(Config{InitialBackoff: time.Millisecond, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, func(path string) error {
+		done <- path
+		return nil
+	})
+
+	q.Add("/busy", syscall.EBUSY)
+	select {
+	case path := <-done:
+		if path != "/busy" {
+			t.Errorf("Unexpected retry of %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the retry")
+	}
+}
+
+func TestLoadVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.json")
+	os.WriteFile(path, []byte(`{"version": 99, "entries": []}`), 0o644)
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+	if entries, err := Load(filepath.Join(t.TempDir(), "missing.json")); err != nil || entries != nil {
+		t.Errorf("Expected a missing file to be an empty queue, got %v, %v", entries, err)
+	}
+}
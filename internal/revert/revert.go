@@ -0,0 +1,241 @@
+// Package revert clears ignore attributes the daemon set, selected from its
+// audit log by time, rule or path.
+//
+// The daemon should be stopped while reverting: it appends to the same log
+// and marks the paths again as long as their rules still match.
+package revert
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/audit"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/xattr"
+)
+
+// Filter selects marks to revert. Every non-empty field must match.
+type Filter struct {
+	// Since selects marks made at or after a time
+	Since time.Time
+	// Rule selects marks by rule, given as a pattern, as file:line or in
+	// full as recorded
+	Rule string
+	// Prefix selects marks on a path or below it
+	Prefix string
+}
+
+// Empty reports whether the filter selects everything
+func (f Filter) Empty() bool {
+	return f.Since.IsZero() && f.Rule == "" && f.Prefix == ""
+}
+
+// matches reports whether a set record is selected
+func (f Filter) matches(r audit.Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if f.Rule != "" && !matchesRule(r.Rule, f.Rule) {
+		return false
+	}
+	if f.Prefix != "" {
+		prefix := filepath.Clean(f.Prefix)
+		if r.Path != prefix && !strings.HasPrefix(r.Path, prefix+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesRule compares a recorded rule, "file:line: pattern" optionally
+// followed by " in section name", with a rule given by the user
+func matchesRule(recorded, rule string) bool {
+	if recorded == rule || strings.HasPrefix(recorded, rule+": ") {
+		return true
+	}
+	_, pattern, ok := strings.Cut(recorded, ": ")
+	if !ok {
+		return false
+	}
+	if i := strings.LastIndex(pattern, " in section "); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return pattern == rule
+}
+
+// Skip is a selected mark that is left alone
+type Skip struct {
+	Path   string
+	Reason string
+}
+
+// Plan lists the marks to clear and the ones left alone
+type Plan struct {
+	Revert  []audit.Record
+	Skipped []Skip
+}
+
+// Config holds revert configuration
+type Config struct {
+	// AuditLog is the log the daemon recorded its marks in
+	AuditLog string
+	// AuditMaxSize is the size at which the log is rotated when removals
+	// are recorded, defaulting to that of the audit package
+	AuditMaxSize int64
+	Filter       Filter
+	// IsIgnored and RemoveIgnored default to the xattr package. noFollow
+	// is the recorded NoFollow of the mark: the attribute is that of a
+	// symbolic link rather than of its target.
+	IsIgnored     func(path string, noFollow bool) (bool, error)
+	RemoveIgnored func(path string, noFollow bool) error
+	Logger        *slog.Logger
+}
+
+// Reverter plans and applies reverts
+type Reverter struct {
+	config Config
+}
+
+// NewReverter creates a reverter
+func NewReverter(cfg Config) (*Reverter, error) {
+	if cfg.AuditLog == "" {
+		return nil, fmt.Errorf("an audit log is required")
+	}
+	if cfg.Filter.Empty() {
+		return nil, fmt.Errorf("a time, rule or path is required to select what to revert")
+	}
+	if cfg.IsIgnored == nil {
+		cfg.IsIgnored = func(path string, noFollow bool) (bool, error) {
+			return xattr.Marker{NoFollow: noFollow}.IsIgnored(path)
+		}
+	}
+	if cfg.RemoveIgnored == nil {
+		cfg.RemoveIgnored = func(path string, noFollow bool) error {
+			return xattr.Marker{NoFollow: noFollow}.RemoveIgnored(path)
+		}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Reverter{config: cfg}, nil
+}
+
+// Plan finds the selected marks that can be cleared. Only paths whose last
+// recorded change is a mark are candidates, and a mark is skipped when the
+// path is gone, was replaced since, or no longer carries the attribute, so
+// that attributes the daemon did not set are never touched.
+func (r *Reverter) Plan() (*Plan, error) {
+	records, err := audit.ReadAll(r.config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last real change per path; dry runs changed nothing
+	last := make(map[string]audit.Record)
+	for _, rec := range records {
+		if !rec.DryRun {
+			last[rec.Path] = rec
+		}
+	}
+
+	plan := &Plan{}
+	for _, rec := range last {
+		if rec.Action != audit.ActionSet || !r.config.Filter.matches(rec) {
+			continue
+		}
+		if reason := r.check(rec); reason != "" {
+			plan.Skipped = append(plan.Skipped, Skip{Path: rec.Path, Reason: reason})
+			continue
+		}
+		plan.Revert = append(plan.Revert, rec)
+	}
+	sort.Slice(plan.Revert, func(i, j int) bool { return plan.Revert[i].Path < plan.Revert[j].Path })
+	sort.Slice(plan.Skipped, func(i, j int) bool { return plan.Skipped[i].Path < plan.Skipped[j].Path })
+	return plan, nil
+}
+
+// check returns why a mark must be left alone, or "" if it can be cleared
+func (r *Reverter) check(rec audit.Record) string {
+	info, err := rec.Stat()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "no longer exists"
+		}
+		return err.Error()
+	}
+	if rec.Inode != 0 && state.Inode(info) != rec.Inode {
+		return "replaced since it was marked"
+	}
+	ignored, err := r.config.IsIgnored(rec.Path, rec.NoFollow)
+	if err != nil {
+		return err.Error()
+	}
+	if !ignored {
+		return "attribute already cleared"
+	}
+	return ""
+}
+
+// Result summarizes an applied plan
+type Result struct {
+	Reverted int
+	Failed   []Skip
+}
+
+// Apply clears the attribute on every path in the plan, of the link itself
+// where the mark was, and records each removal in the audit log
+func (r *Reverter) Apply(plan *Plan) (*Result, error) {
+	l, err := audit.Open(audit.Config{
+		Path:    r.config.AuditLog,
+		MaxSize: r.config.AuditMaxSize,
+		Logger:  r.config.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	res := &Result{}
+	for _, rec := range plan.Revert {
+		if err := r.config.RemoveIgnored(rec.Path, rec.NoFollow); err != nil {
+			res.Failed = append(res.Failed, Skip{Path: rec.Path, Reason: err.Error()})
+			continue
+		}
+		res.Reverted++
+
+		var inode uint64
+		if info, err := rec.Stat(); err == nil {
+			inode = state.Inode(info)
+		}
+		err := l.Write(audit.Record{
+			Path:     rec.Path,
+			Inode:    inode,
+			Action:   audit.ActionRemove,
+			Rule:     rec.Rule,
+			Source:   audit.SourceRevert,
+			NoFollow: rec.NoFollow,
+		})
+		if err != nil {
+			r.config.Logger.Error("Failed to write audit log", "error", err)
+		}
+	}
+	return res, nil
+}
+
+// ParseSince parses a time given as a date, an RFC 3339 timestamp or an
+// age such as 2h or 3d before now
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := matcher.ParseTime(s); err == nil {
+		return t, nil
+	}
+	if age, err := matcher.ParseAge(s); err == nil {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected YYYY-MM-DD, RFC 3339 or an age like 2h", s)
+}
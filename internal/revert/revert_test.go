@@ -0,0 +1,277 @@
+package revert
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/audit"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+)
+
+func TestRevert(t *testing.T) {
+	root := t.TempDir()
+	paths := make(map[string]string)
+	inodes := make(map[string]uint64)
+	for _, name := range []string{"a.log", "b.log", "build", "old.log", "manual.bin", "replaced.log", "cleared.log", "dry.log", "other/c.log"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		info, _ := os.Lstat(path)
+		paths[name] = path
+		inodes[name] = state.Inode(info)
+	}
+
+	logPath := filepath.Join(root, "audit.jsonl")
+	l, err := audit.Open(audit.Config{Path: logPath})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	cutoff := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	write := func(name string, at time.Time, action audit.Action, rule string, dryRun bool) {
+		l.Write(audit.Record{Time: at, Path: paths[name], Inode: inodes[name], Action: action, Rule: rule, Source: audit.SourcePoller, DryRun: dryRun})
+	}
+	after := cutoff.Add(time.Hour)
+	logRule := "/d/.dropboxignore:3: *.log"
+	write("a.log", after, audit.ActionSet, logRule, false)
+	write("b.log", after, audit.ActionSet, logRule, false)
+	write("other/c.log", after, audit.ActionSet, logRule, false)
+	write("build", after, audit.ActionSet, "/d/.dropboxignore:1: build/ in section linux", false)
+	write("old.log", cutoff.Add(-time.Hour), audit.ActionSet, logRule, false)
+	// replaced.log was marked as another file, since replaced
+	inodes["replaced.log"]++
+	write("replaced.log", after, audit.ActionSet, logRule, false)
+	write("cleared.log", after, audit.ActionSet, logRule, false)
+	write("dry.log", after, audit.ActionSet, logRule, true)
+	// Already reverted
+	write("b.log", after.Add(time.Minute), audit.ActionRemove, logRule, false)
+	l.Close()
+
+	// Every path but cleared.log carries the attribute, including
+	// manual.bin, which the daemon never marked
+	ignored := map[string]bool{}
+	for name, path := range paths {
+		ignored[path] = name != "cleared.log"
+	}
+	var removed []string
+
+	newReverter := func(filter Filter) *Reverter {
+		r, err := NewReverter(Config{
+			AuditLog:      logPath,
+			Filter:        filter,
+			IsIgnored:     func(path string, _ bool) (bool, error) { return ignored[path], nil },
+			RemoveIgnored: func(path string, _ bool) error { removed = append(removed, path); ignored[path] = false; return nil },
+			Logger:        slog.New(slog.DiscardHandler),
+		})
+		if err != nil {
+			t.Fatalf("NewReverter failed: %v", err)
+		}
+		return r
+	}
+
+	plan, err := newReverter(Filter{Since: cutoff, Rule: "*.log"}).Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	var planned []string
+	for _, rec := range plan.Revert {
+		planned = append(planned, rec.Path)
+	}
+	expected := []string{paths["a.log"], paths["other/c.log"]}
+	if len(planned) != len(expected) || planned[0] != expected[0] || planned[1] != expected[1] {
+		t.Errorf("Expected %v, got %v", expected, planned)
+	}
+	skipped := make(map[string]string)
+	for _, s := range plan.Skipped {
+		skipped[s.Path] = s.Reason
+	}
+	if skipped[paths["cleared.log"]] != "attribute already cleared" {
+		t.Errorf("Unexpected skips %v", skipped)
+	}
+	if skipped[paths["replaced.log"]] != "replaced since it was marked" {
+		t.Errorf("Unexpected skips %v", skipped)
+	}
+
+	// Narrow by path, by file:line and by a sectioned rule's pattern
+	for filter, want := range map[Filter]string{
+		{Prefix: filepath.Join(root, "other")}:   paths["other/c.log"],
+		{Rule: "/d/.dropboxignore:1"}:            paths["build"],
+		{Rule: "build/"}:                         paths["build"],
+		{Rule: "build/", Prefix: paths["build"]}: paths["build"],
+	} {
+		plan, err := newReverter(filter).Plan()
+		if err != nil {
+			t.Fatalf("Plan failed: %v", err)
+		}
+		if len(plan.Revert) != 1 || plan.Revert[0].Path != want {
+			t.Errorf("Filter %+v: expected %s, got %+v", filter, want, plan.Revert)
+		}
+	}
+
+	res, err := newReverter(Filter{Since: cutoff, Rule: "*.log"}).Apply(plan)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if res.Reverted != 2 || len(removed) != 2 {
+		t.Errorf("Expected 2 reverts, got %+v, removed %v", res, removed)
+	}
+
+	// The removals are logged, so planning again finds nothing
+	plan, err = newReverter(Filter{Since: cutoff, Rule: "*.log"}).Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Revert) != 0 {
+		t.Errorf("Expected nothing left to revert, got %+v", plan.Revert)
+	}
+	if ignored[paths["manual.bin"]] != true {
+		t.Error("Expected manual.bin to keep its attribute")
+	}
+}
+
+func TestRevertNoFollow(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "target.log")
+	link := filepath.Join(root, "link.log")
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	logPath := filepath.Join(root, "audit.jsonl")
+	l, err := audit.Open(audit.Config{Path: logPath})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	for _, path := range []string{target, link} {
+		info, _ := os.Lstat(path)
+		l.Write(audit.Record{Path: path, Inode: state.Inode(info), Action: audit.ActionSet, Rule: "*.log", Source: audit.SourcePoller, NoFollow: path == link})
+	}
+	l.Close()
+
+	// The link and its target carry separate attributes
+	type attr struct {
+		path     string
+		noFollow bool
+	}
+	ignored := map[attr]bool{{target, false}: true, {link, true}: true}
+	r, err := NewReverter(Config{
+		AuditLog:      logPath,
+		AuditMaxSize:  1,
+		Filter:        Filter{Rule: "*.log"},
+		IsIgnored:     func(path string, noFollow bool) (bool, error) { return ignored[attr{path, noFollow}], nil },
+		RemoveIgnored: func(path string, noFollow bool) error { delete(ignored, attr{path, noFollow}); return nil },
+		Logger:        slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewReverter failed: %v", err)
+	}
+	plan, err := r.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Revert) != 2 {
+		t.Fatalf("Expected both marks to be reverted, got %+v, skipped %+v", plan.Revert, plan.Skipped)
+	}
+	if _, err := r.Apply(plan); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(ignored) != 0 {
+		t.Errorf("Expected each attribute to be cleared where it was set, left %v", ignored)
+	}
+
+	// Removals are logged with the configured rotation size
+	if _, err := os.Stat(logPath + ".1"); err != nil {
+		t.Errorf("Expected the audit log to rotate at the configured size: %v", err)
+	}
+	records, err := audit.ReadAll(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	removals := 0
+	for _, rec := range records {
+		if rec.Action != audit.ActionRemove {
+			continue
+		}
+		removals++
+		if rec.NoFollow != (rec.Path == link) {
+			t.Errorf("Unexpected NoFollow in %+v", rec)
+		}
+	}
+	if removals != 2 {
+		t.Errorf("Expected 2 logged removals, got %d", removals)
+	}
+}
+
+func TestRevertFollowedLink(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "target.log")
+	link := filepath.Join(root, "link.log")
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	// Under the follow policy the daemon records the target's inode
+	logPath := filepath.Join(root, "audit.jsonl")
+	l, err := audit.Open(audit.Config{Path: logPath})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	info, _ := os.Stat(target)
+	l.Write(audit.Record{Path: link, Inode: state.Inode(info), Action: audit.ActionSet, Rule: "*.log", Source: audit.SourcePoller})
+	l.Close()
+
+	var removed []string
+	r, err := NewReverter(Config{
+		AuditLog:      logPath,
+		Filter:        Filter{Rule: "*.log"},
+		IsIgnored:     func(path string, noFollow bool) (bool, error) { return !noFollow, nil },
+		RemoveIgnored: func(path string, _ bool) error { removed = append(removed, path); return nil },
+		Logger:        slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewReverter failed: %v", err)
+	}
+	plan, err := r.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Revert) != 1 || plan.Revert[0].Path != link {
+		t.Fatalf("Expected the followed link to be reverted, got %+v, skipped %+v", plan.Revert, plan.Skipped)
+	}
+	if res, err := r.Apply(plan); err != nil || res.Reverted != 1 || len(removed) != 1 {
+		t.Errorf("Unexpected result %+v, %v, removed %v", res, err, removed)
+	}
+}
+
+func TestNewReverterRequiresFilter(t *testing.T) {
+	if _, err := NewReverter(Config{AuditLog: "audit.jsonl"}); err == nil {
+		t.Error("Expected an error without a filter")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	got, err := ParseSince("2h", now)
+	if err != nil || !got.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Unexpected result %v, %v", got, err)
+	}
+	got, err = ParseSince("2026-02-01T10:00:00Z", now)
+	if err != nil || !got.Equal(time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected result %v, %v", got, err)
+	}
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -0,0 +1,284 @@
+// Package scaffold detects the project types in a directory and writes a
+// .dropboxignore with rules for their generated content
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+// FileName is the ignore file written by Init
+const FileName = ".dropboxignore"
+
+// rule is a line for an ecosystem. Anchored rules only cover the directory
+// the marker was found in, others apply at any depth below it.
+type rule struct {
+	pattern  string
+	anchored bool
+}
+
+// Ecosystem is a kind of project and the content it generates
+type Ecosystem struct {
+	Name string
+	// Markers are file names or globs identifying a project root
+	Markers []string
+	rules   []rule
+}
+
+// Ecosystems are the project types Detect recognizes
+var Ecosystems = []Ecosystem{
+	{Name: "Node.js", Markers: []string{"package.json"}, rules: []rule{
+		{"node_modules/", false},
+		{".next/", false},
+		{".nuxt/", false},
+		{".svelte-kit/", false},
+		{".parcel-cache/", false},
+		{".turbo/", false},
+	}},
+	{Name: "Rust", Markers: []string{"Cargo.toml"}, rules: []rule{
+		{"target/", true},
+	}},
+	{Name: "Go", Markers: []string{"go.mod"}, rules: []rule{
+		{"vendor/", true},
+	}},
+	{Name: "Python", Markers: []string{"pyproject.toml", "setup.py", "setup.cfg", "requirements.txt", "Pipfile"}, rules: []rule{
+		{"__pycache__/", false},
+		{".venv/", true},
+		{".pytest_cache/", false},
+		{".mypy_cache/", false},
+		{".ruff_cache/", false},
+		{".tox/", true},
+		{"*.egg-info/", false},
+		{"build/", true},
+		{"dist/", true},
+	}},
+	{Name: "Xcode", Markers: []string{"*.xcodeproj", "*.xcworkspace"}, rules: []rule{
+		{"DerivedData/", false},
+		{"xcuserdata/", false},
+		{"build/", true},
+	}},
+	{Name: "Swift Package Manager", Markers: []string{"Package.swift"}, rules: []rule{
+		{".build/", true},
+		{".swiftpm/", true},
+	}},
+	{Name: "Maven", Markers: []string{"pom.xml"}, rules: []rule{
+		{"target/", true},
+	}},
+	{Name: "Gradle", Markers: []string{"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts"}, rules: []rule{
+		{".gradle/", false},
+		{"build/", true},
+	}},
+	{Name: ".NET", Markers: []string{"*.csproj", "*.fsproj", "*.vbproj", "*.sln"}, rules: []rule{
+		{"bin/", true},
+		{"obj/", true},
+	}},
+	{Name: "CMake", Markers: []string{"CMakeLists.txt"}, rules: []rule{
+		{"build/", true},
+		{"CMakeFiles/", false},
+	}},
+	{Name: "Dart", Markers: []string{"pubspec.yaml"}, rules: []rule{
+		{".dart_tool/", false},
+		{"build/", true},
+	}},
+	{Name: "Elixir", Markers: []string{"mix.exs"}, rules: []rule{
+		{"_build/", true},
+		{"deps/", true},
+	}},
+	{Name: "Ruby", Markers: []string{"Gemfile"}, rules: []rule{
+		{".bundle/", true},
+		{"vendor/bundle/", true},
+	}},
+	{Name: "PHP", Markers: []string{"composer.json"}, rules: []rule{
+		{"vendor/", true},
+	}},
+	{Name: "Haskell", Markers: []string{"stack.yaml", "cabal.project"}, rules: []rule{
+		{".stack-work/", true},
+		{"dist-newstyle/", true},
+	}},
+	{Name: "Terraform", Markers: []string{"*.tf"}, rules: []rule{
+		{".terraform/", false},
+	}},
+}
+
+// Detection is an ecosystem found in a directory
+type Detection struct {
+	Ecosystem *Ecosystem
+	// Dir is relative to the scanned directory, "." for the directory itself
+	Dir string
+	// Marker is the file that identified the project
+	Marker string
+}
+
+// Rules returns the ignore lines for the detection, relative to the
+// scanned directory
+func (d Detection) Rules() []string {
+	rules := make([]string, 0, len(d.Ecosystem.rules))
+	dir := matcher.EscapeGlob(d.Dir)
+	for _, r := range d.Ecosystem.rules {
+		switch {
+		case !r.anchored && d.Dir == ".":
+			rules = append(rules, r.pattern)
+		case !r.anchored:
+			rules = append(rules, "/"+dir+"/**/"+r.pattern)
+		default:
+			rules = append(rules, "/"+path.Join(dir, r.pattern)+"/")
+		}
+	}
+	return rules
+}
+
+// Detect finds the ecosystems in dir and its immediate subdirectories,
+// so that the projects of a monorepo are covered too
+func Detect(dir string) ([]Detection, error) {
+	detections, err := detectIn(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || isGenerated(entry.Name()) {
+			continue
+		}
+		sub, err := detectIn(filepath.Join(dir, entry.Name()), entry.Name())
+		if err != nil {
+			continue
+		}
+		detections = append(detections, sub...)
+	}
+	return detections, nil
+}
+
+// detectIn matches the markers against the entries of one directory
+func detectIn(dir, rel string) ([]Detection, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var detections []Detection
+	for i := range Ecosystems {
+		e := &Ecosystems[i]
+	markers:
+		for _, marker := range e.Markers {
+			for _, entry := range entries {
+				if ok, _ := path.Match(marker, entry.Name()); ok {
+					detections = append(detections, Detection{Ecosystem: e, Dir: rel, Marker: path.Join(rel, entry.Name())})
+					break markers
+				}
+			}
+		}
+	}
+	return detections, nil
+}
+
+// isGenerated reports whether a directory name is covered by a rule that
+// applies at any depth, such as node_modules, whose contents are not
+// projects of their own
+func isGenerated(name string) bool {
+	for _, e := range Ecosystems {
+		for _, r := range e.rules {
+			if !r.anchored && strings.TrimSuffix(r.pattern, "/") == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Merge appends the rules for detections to an existing ignore file,
+// leaving its content as is and skipping rules it already has on every
+// machine; rules only found in conditional sections are added again. It
+// returns the new content and the number of rules added.
+func Merge(existing string, detections []Detection) (string, int) {
+	present := make(map[string]bool)
+	for _, line := range matcher.UnconditionalLines(existing) {
+		present[line] = true
+	}
+
+	var b strings.Builder
+	b.WriteString(existing)
+	if existing == "" {
+		b.WriteString("# Keeps generated project content out of Dropbox.\n")
+		b.WriteString("# Written by dbxignore init; edit as needed.\n")
+	} else if !strings.HasSuffix(existing, "\n") {
+		b.WriteString("\n")
+	}
+	// Rules appended inside a trailing section would only apply under it
+	closeSection := matcher.SectionAtEnd(existing) != ""
+
+	added := 0
+	for _, d := range detections {
+		var lines []string
+		for _, r := range d.Rules() {
+			if !present[r] {
+				present[r] = true
+				lines = append(lines, r)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if closeSection {
+			b.WriteString("[*]\n")
+			closeSection = false
+		}
+		fmt.Fprintf(&b, "\n# %s (%s)\n", d.Ecosystem.Name, d.Marker)
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+		added += len(lines)
+	}
+	return b.String(), added
+}
+
+// Result describes what Init did or would do
+type Result struct {
+	File       string
+	Detections []Detection
+	Content    string
+	Added      int
+	// Hidden is set when File is new and a .dropboxignore above it governs
+	// dir: only the closest file applies, so its rules stop applying below
+	// dir once File is created
+	Hidden string
+}
+
+// Init detects the ecosystems in dir and merges their rules into its
+// ignore file. With dryRun the file is left untouched.
+func Init(dir string, dryRun bool) (*Result, error) {
+	detections, err := Detect(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(dir, FileName)
+	existing, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	content, added := Merge(string(existing), detections)
+	res := &Result{File: file, Detections: detections, Content: content, Added: added, Hidden: matcher.HiddenRules(file)}
+	if dryRun || added == 0 {
+		return res, nil
+	}
+	return res, os.WriteFile(file, []byte(content), 0644)
+}
+
+// Names lists the detected ecosystem names once each, in order
+func (r *Result) Names() []string {
+	var names []string
+	for _, d := range r.Detections {
+		if !slices.Contains(names, d.Ecosystem.Name) {
+			names = append(names, d.Ecosystem.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,192 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+func createFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	createFiles(t, root,
+		"package.json",
+		"crates/core/Cargo.toml", // too deep
+		"api/go.mod",
+		"App.xcodeproj/project.pbxproj",
+		"node_modules/left-pad/package.json",
+		".cache/pyproject.toml",
+	)
+
+	detections, err := Detect(root)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	var got []string
+	for _, d := range detections {
+		got = append(got, d.Ecosystem.Name+" "+d.Marker)
+	}
+	expected := []string{"Node.js package.json", "Xcode App.xcodeproj", "Go api/go.mod"}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	python := &Ecosystems[3]
+	if python.Name != "Python" {
+		t.Fatalf("Expected Python, got %s", python.Name)
+	}
+	rules := Detection{Ecosystem: python, Dir: "svc [old]"}.Rules()
+	if rules[0] != `/svc \[old]/**/__pycache__/` || rules[1] != `/svc \[old]/.venv/` {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+	rules = Detection{Ecosystem: python, Dir: "."}.Rules()
+	if rules[0] != "__pycache__/" || rules[1] != "/.venv/" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+}
+
+func TestInit(t *testing.T) {
+	root := t.TempDir()
+	createFiles(t, root, "Cargo.toml", "web/package.json")
+	file := filepath.Join(root, FileName)
+	if err := os.WriteFile(file, []byte("# mine\n/target/\n*.log"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	res, err := Init(root, true)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != "# mine\n/target/\n*.log" {
+		t.Errorf("Dry run changed the file: %q", content)
+	}
+
+	expected := "# mine\n/target/\n*.log\n" +
+		"\n# Node.js (web/package.json)\n" +
+		"/web/**/node_modules/\n/web/**/.next/\n/web/**/.nuxt/\n/web/**/.svelte-kit/\n/web/**/.parcel-cache/\n/web/**/.turbo/\n"
+	if res.Content != expected {
+		t.Errorf("Unexpected content:\n%s\nexpected:\n%s", res.Content, expected)
+	}
+
+	if _, err := Init(root, false); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	content, _ = os.ReadFile(file)
+	if string(content) != expected {
+		t.Errorf("Unexpected file content %q", content)
+	}
+
+	// Running again adds nothing
+	res, err = Init(root, false)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if res.Added != 0 {
+		t.Errorf("Expected no rules added, got %d", res.Added)
+	}
+
+	// The written rules work with the matcher
+	createFiles(t, root, "web/node_modules/x/index.js", "target/debug/app", "web/src/index.js")
+	m, err := matcher.NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	for path, ignored := range map[string]bool{
+		"web/node_modules": true,
+		"target":           true,
+		"web/src":          false,
+	} {
+		res, err := m.Explain(filepath.Join(root, path))
+		if err != nil {
+			t.Fatalf("Explain failed: %v", err)
+		}
+		if res.Ignored != ignored {
+			t.Errorf("Expected %s ignored=%v", path, ignored)
+		}
+	}
+}
+
+func TestInitAfterSection(t *testing.T) {
+	root := t.TempDir()
+	createFiles(t, root, "package.json", "node_modules/x/index.js")
+	file := filepath.Join(root, FileName)
+	// node_modules/ is only ignored on another host, and the file ends in
+	// a section of its own
+	existing := "[host:other]\nnode_modules/\n[os:plan9]\nscratch/\n"
+	if err := os.WriteFile(file, []byte(existing), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	res, err := Init(root, false)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if !strings.HasPrefix(res.Content, existing+"[*]\n") || !strings.Contains(res.Content, "\nnode_modules/\n.next/") {
+		t.Errorf("Expected the section closed and node_modules/ added again, got:\n%s", res.Content)
+	}
+
+	m, err := matcher.NewMatcherWithConfig(matcher.Config{Host: matcher.Host{Hostname: "laptop", OS: "linux"}})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	if ignored, err := m.ShouldIgnore(filepath.Join(root, "node_modules")); err != nil || !ignored {
+		t.Errorf("Expected node_modules to be ignored on every machine, got %v, %v", ignored, err)
+	}
+}
+
+func TestInitNewFile(t *testing.T) {
+	root := t.TempDir()
+	createFiles(t, root, "mix.exs")
+	res, err := Init(root, false)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	content, _ := os.ReadFile(res.File)
+	if !strings.HasPrefix(string(content), "# Keeps generated") || !strings.HasSuffix(string(content), "# Elixir (mix.exs)\n/_build/\n/deps/\n") {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestInitBelowIgnoreFile(t *testing.T) {
+	base := t.TempDir()
+	proj := filepath.Join(base, "proj")
+	createFiles(t, base, ".dropboxignore", "proj/mix.exs")
+
+	res, err := Init(proj, true)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if governing := filepath.Join(base, FileName); res.Hidden != governing {
+		t.Errorf("Expected a new file in proj to hide %s, got %q", governing, res.Hidden)
+	}
+
+	// Once created, the file is the governing one and hides nothing new
+	if _, err := Init(proj, false); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	res, err = Init(proj, true)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if res.Hidden != "" {
+		t.Errorf("Expected an existing file to hide nothing, got %q", res.Hidden)
+	}
+}
@@ -0,0 +1,272 @@
+// Package snapshot captures which paths under a root carry the ignore
+// attribute into a portable file and restores that state later, following
+// entries that were moved in between by inode.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/state"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/xattr"
+)
+
+// version is the current snapshot file format
+const version = 1
+
+// Entry is an ignored path
+type Entry struct {
+	// Path is relative to the root, with forward slashes
+	Path  string `json:"path"`
+	Inode uint64 `json:"inode,omitempty"`
+	Dir   bool   `json:"dir,omitempty"`
+}
+
+// Snapshot is the ignore state of a tree
+type Snapshot struct {
+	Version int       `json:"version"`
+	Root    string    `json:"root"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Config holds snapshot configuration
+type Config struct {
+	Root string
+	// IsIgnored, SetIgnored and RemoveIgnored default to the xattr package
+	IsIgnored     func(path string) (bool, error)
+	SetIgnored    func(path string) error
+	RemoveIgnored func(path string) error
+	Logger        *slog.Logger
+}
+
+// Snapshotter captures and restores the ignore state of a root
+type Snapshotter struct {
+	config Config
+}
+
+// NewSnapshotter creates a snapshotter
+func NewSnapshotter(cfg Config) (*Snapshotter, error) {
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Root = root
+	if cfg.IsIgnored == nil {
+		cfg.IsIgnored = xattr.IsIgnored
+	}
+	if cfg.SetIgnored == nil {
+		cfg.SetIgnored = xattr.SetIgnored
+	}
+	if cfg.RemoveIgnored == nil {
+		cfg.RemoveIgnored = xattr.RemoveIgnored
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Snapshotter{config: cfg}, nil
+}
+
+// node is a path seen while walking the current tree
+type node struct {
+	path    string
+	inode   uint64
+	dir     bool
+	ignored bool
+}
+
+// walk visits every entry under the root, including the hidden directories
+// and node_modules the poller skips, since attributes set by hand can be
+// anywhere. With all set it descends into ignored directories too, which
+// restoring needs to find moved entries. Unreadable directories are logged
+// and skipped.
+func (s *Snapshotter) walk(all bool, visit func(n node)) error {
+	return filepath.WalkDir(s.config.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.config.Root {
+				return err
+			}
+			s.config.Logger.Warn("Failed to read", "path", path, "error", err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == s.config.Root {
+			return nil
+		}
+		ignored, err := s.config.IsIgnored(path)
+		if err != nil {
+			s.config.Logger.Warn("Failed to check xattr", "path", path, "error", err)
+			return nil
+		}
+		// Entries are matched by inode, so every one is stat'ed
+		info, err := d.Info()
+		if err != nil {
+			s.config.Logger.Warn("Failed to get info", "path", path, "error", err)
+			return nil
+		}
+		visit(node{path: path, inode: state.Inode(info), dir: d.IsDir(), ignored: ignored})
+		if ignored && d.IsDir() && !all {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// Capture records the ignored paths anywhere under the root. The contents
+// of ignored directories are left out, as the directory covers them.
+func (s *Snapshotter) Capture() (*Snapshot, error) {
+	snap := &Snapshot{Version: version, Root: s.config.Root, Created: time.Now()}
+	err := s.walk(false, func(n node) {
+		if n.ignored {
+			snap.Entries = append(snap.Entries, Entry{Path: s.rel(n.path), Inode: n.inode, Dir: n.dir})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snap.Entries, func(i, j int) bool { return snap.Entries[i].Path < snap.Entries[j].Path })
+	return snap, nil
+}
+
+func (s *Snapshotter) rel(path string) string {
+	rel, err := filepath.Rel(s.config.Root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// ChangeKind is what restoring does to a path
+type ChangeKind string
+
+const (
+	// Set marks a path that lost its attribute
+	Set ChangeKind = "set"
+	// Moved marks an entry found by inode at a new path
+	Moved ChangeKind = "moved"
+	// Missing is an entry found neither by path nor by inode
+	Missing ChangeKind = "missing"
+	// Extra is a path ignored now but not in the snapshot
+	Extra ChangeKind = "extra"
+)
+
+// Change is one difference between a snapshot and the tree
+type Change struct {
+	Kind ChangeKind
+	// Path is where the change applies, absolute
+	Path string
+	// From is the path in the snapshot, relative to the root
+	From string
+}
+
+// Diff compares a snapshot with the current tree. Entries that are
+// ignored already, at their path or where their inode moved, are left out.
+func (s *Snapshotter) Diff(snap *Snapshot) ([]Change, error) {
+	byPath := make(map[string]node)
+	byInode := make(map[uint64]node)
+	err := s.walk(true, func(n node) {
+		byPath[n.path] = n
+		if n.inode != 0 {
+			byInode[n.inode] = n
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	wanted := make(map[string]bool)
+	for _, e := range snap.Entries {
+		path := filepath.Join(s.config.Root, filepath.FromSlash(e.Path))
+		n, ok := byPath[path]
+		kind := Set
+		if !ok || n.dir != e.Dir {
+			n, ok = byInode[e.Inode]
+			kind = Moved
+			if !ok || e.Inode == 0 || n.dir != e.Dir {
+				changes = append(changes, Change{Kind: Missing, Path: path, From: e.Path})
+				continue
+			}
+		}
+		wanted[n.path] = true
+		if !n.ignored {
+			changes = append(changes, Change{Kind: kind, Path: n.path, From: e.Path})
+		}
+	}
+
+	for path, n := range byPath {
+		if n.ignored && !wanted[path] && !coveredBy(path, wanted, s.config.Root) {
+			changes = append(changes, Change{Kind: Extra, Path: path, From: s.rel(path)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// coveredBy reports whether an ancestor of path is wanted, in which case
+// the path's own attribute does not matter
+func coveredBy(path string, wanted map[string]bool, root string) bool {
+	for dir := filepath.Dir(path); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if wanted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreResult summarizes a restore
+type RestoreResult struct {
+	Set     int
+	Cleared int
+	Failed  []error
+}
+
+// Restore applies the changes from Diff. Extra paths are only cleared
+// when exact is set.
+func (s *Snapshotter) Restore(changes []Change, exact bool) *RestoreResult {
+	res := &RestoreResult{}
+	for _, c := range changes {
+		switch {
+		case c.Kind == Set || c.Kind == Moved:
+			if err := s.config.SetIgnored(c.Path); err != nil {
+				res.Failed = append(res.Failed, fmt.Errorf("%s: %w", c.Path, err))
+				continue
+			}
+			res.Set++
+		case c.Kind == Extra && exact:
+			if err := s.config.RemoveIgnored(c.Path); err != nil {
+				res.Failed = append(res.Failed, fmt.Errorf("%s: %w", c.Path, err))
+				continue
+			}
+			res.Cleared++
+		}
+	}
+	return res
+}
+
+// Write encodes a snapshot
+func (snap *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// Read decodes a snapshot
+func Read(r io.Reader) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
@@ -0,0 +1,180 @@
+package snapshot
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeAttrs stands in for the attribute, which tmpfs may not support
+type fakeAttrs map[string]bool
+
+func (f fakeAttrs) config(root string) Config {
+	return Config{
+		Root:          root,
+		IsIgnored:     func(path string) (bool, error) { return f[path], nil },
+		SetIgnored:    func(path string) error { f[path] = true; return nil },
+		RemoveIgnored: func(path string) error { delete(f, path); return nil },
+		Logger:        slog.New(slog.DiscardHandler),
+	}
+}
+
+func TestCaptureAndRestore(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"build/out.o", "docs/big.pdf", "notes.txt", "cache/blob", "gone/x"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	attrs := fakeAttrs{
+		filepath.Join(root, "build"):        true,
+		filepath.Join(root, "build/out.o"):  true, // covered by build
+		filepath.Join(root, "docs/big.pdf"): true,
+		filepath.Join(root, "cache"):        true,
+		filepath.Join(root, "gone"):         true,
+	}
+
+	s, err := NewSnapshotter(attrs.config(root))
+	if err != nil {
+		t.Fatalf("NewSnapshotter failed: %v", err)
+	}
+	snap, err := s.Capture()
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	var paths []string
+	for _, e := range snap.Entries {
+		paths = append(paths, e.Path)
+	}
+	expected := []string{"build", "cache", "docs/big.pdf", "gone"}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, paths)
+		}
+	}
+
+	// Round trip through the file format
+	var buf bytes.Buffer
+	if err := snap.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	snap, err = Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	// Reorganize: docs moves, gone is deleted, the attributes are lost and
+	// notes.txt is marked by hand
+	if err := os.Rename(filepath.Join(root, "docs"), filepath.Join(root, "archive")); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(root, "gone")); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	for path := range attrs {
+		delete(attrs, path)
+	}
+	attrs[filepath.Join(root, "cache")] = true
+	attrs[filepath.Join(root, "notes.txt")] = true
+
+	changes, err := s.Diff(snap)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	got := make(map[string]ChangeKind)
+	for _, c := range changes {
+		rel, _ := filepath.Rel(root, c.Path)
+		got[rel] = c.Kind
+	}
+	want := map[string]ChangeKind{
+		"archive/big.pdf": Moved,
+		"build":           Set,
+		"gone":            Missing,
+		"notes.txt":       Extra,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	for path, kind := range want {
+		if got[path] != kind {
+			t.Errorf("Expected %s to be %s, got %v", path, kind, got)
+		}
+	}
+
+	res := s.Restore(changes, false)
+	if res.Set != 2 || res.Cleared != 0 || len(res.Failed) != 0 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if !attrs[filepath.Join(root, "archive/big.pdf")] || !attrs[filepath.Join(root, "build")] || !attrs[filepath.Join(root, "notes.txt")] {
+		t.Errorf("Unexpected attributes %v", attrs)
+	}
+
+	// An exact restore also clears the extra mark
+	changes, err = s.Diff(snap)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	res = s.Restore(changes, true)
+	if res.Set != 0 || res.Cleared != 1 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if attrs[filepath.Join(root, "notes.txt")] {
+		t.Error("Expected notes.txt to be cleared")
+	}
+}
+
+func TestCaptureHiddenAndNodeModules(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{".config/token", "web/node_modules/x.js", "web/index.js"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	// Marked by hand where the poller does not look
+	attrs := fakeAttrs{
+		filepath.Join(root, ".config/token"):    true,
+		filepath.Join(root, "web/node_modules"): true,
+	}
+
+	s, err := NewSnapshotter(attrs.config(root))
+	if err != nil {
+		t.Fatalf("NewSnapshotter failed: %v", err)
+	}
+	snap, err := s.Capture()
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	if len(snap.Entries) != 2 || snap.Entries[0].Path != ".config/token" || snap.Entries[1].Path != "web/node_modules" {
+		t.Fatalf("Expected both marks to be captured, got %+v", snap.Entries)
+	}
+
+	for path := range attrs {
+		delete(attrs, path)
+	}
+	changes, err := s.Diff(snap)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if res := s.Restore(changes, false); res.Set != 2 {
+		t.Errorf("Expected both marks to be restored, got %+v", res)
+	}
+}
+
+func TestReadRejectsUnknownVersion(t *testing.T) {
+	if _, err := Read(bytes.NewBufferString(`{"version": 99}`)); err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -12,6 +12,9 @@ import (
 type Entry struct {
 	Inode uint64
 	Mtime time.Time
+	// Size lets rules with size predicates be re-evaluated when a file
+	// grows without its mtime changing
+	Size  int64
 	Added time.Time
 }
 
@@ -49,7 +52,7 @@ func (c *Cache) Has(path string, info os.FileInfo) bool {
 	}
 	
 	// Check if file has been modified
-	return entry.Inode == getInode(info) && entry.Mtime.Equal(info.ModTime())
+	return entry.Inode == getInode(info) && entry.Mtime.Equal(info.ModTime()) && entry.Size == info.Size()
 }
 
 // Add adds or updates a file entry in the cache
@@ -60,6 +63,7 @@ func (c *Cache) Add(path string, info os.FileInfo) {
 	c.entries[path] = Entry{ // No pointer, direct value assignment
 		Inode: getInode(info),
 		Mtime: info.ModTime(),
+		Size:  info.Size(),
 		Added: time.Now(),
 	}
 }
@@ -99,4 +103,9 @@ func (c *Cache) Size() int {
 func (c *Cache) StartCleaner(interval time.Duration) func() {
 	// Return a no-op cleanup function
 	return func() {}
-}
\ No newline at end of file
+}
+
+// Inode returns the inode number of a file, or 0 where it is unavailable
+func Inode(info os.FileInfo) uint64 {
+	return getInode(info)
+}
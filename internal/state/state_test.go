@@ -74,6 +74,31 @@ func TestCacheModificationDetection(t *testing.T) {
 	}
 }
 
+func TestCacheSizeChangeDetection(t *testing.T) {
+	cache := NewCache(1 * time.Minute)
+	
+	tmpFile := t.TempDir() + "/test.txt"
+	if err := os.WriteFile(tmpFile, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	
+	info1 := getFileInfo(t, tmpFile)
+	cache.Add(tmpFile, info1)
+	
+	// Grow the file but keep its mtime
+	if err := os.WriteFile(tmpFile, []byte("much longer content"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if err := os.Chtimes(tmpFile, info1.ModTime(), info1.ModTime()); err != nil {
+		t.Fatalf("Failed to reset mtime: %v", err)
+	}
+	
+	info2 := getFileInfo(t, tmpFile)
+	if cache.Has(tmpFile, info2) {
+		t.Error("Cache should detect size change")
+	}
+}
+
 func TestCacheTTL(t *testing.T) {
 	cache := NewCache(50 * time.Millisecond)
 	
@@ -0,0 +1,271 @@
+package suggest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/poller"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/xattr"
+)
+
+// Reasons given for adopted rules
+const (
+	reasonAttribute = "ignore attribute set by hand"
+	reasonExcluded  = "excluded from selective sync"
+	reasonName      = "shared name"
+	reasonExtension = "shared extension"
+)
+
+// AdoptConfig holds configuration for Adopt
+type AdoptConfig struct {
+	Root string
+	// Matcher leaves out paths the current rules already ignore; optional
+	Matcher *matcher.Matcher
+	// IsIgnored reports whether a path carries the ignore attribute.
+	// Defaults to xattr.IsIgnored.
+	IsIgnored func(path string) (bool, error)
+	// Excluded are directories ignored by other means, such as the output
+	// of dropbox exclude list; they need not exist
+	Excluded []string
+	Logger   *slog.Logger
+}
+
+// marked is a path ignored without a rule
+type marked struct {
+	path   string
+	dir    bool
+	size   int64
+	files  int
+	reason string
+}
+
+// Adopt finds paths that are ignored without a rule and returns rules that
+// reproduce them. Paths sharing a name or extension that nothing synced
+// uses are covered by a single rule.
+func Adopt(cfg AdoptConfig) ([]Suggestion, error) {
+	if cfg.IsIgnored == nil {
+		cfg.IsIgnored = xattr.IsIgnored
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*marked
+	isMarked := make(map[string]bool)
+	// Names and extensions of synced paths, which a generalized rule must
+	// not cover
+	syncedNames := make(map[string]bool)
+	syncedExts := make(map[string]bool)
+
+	handler := func(path string, d fs.DirEntry) error {
+		if path == root {
+			return nil
+		}
+		if cfg.Matcher != nil {
+			if res, err := cfg.Matcher.Match(path, d); err == nil && res.Ignored {
+				return skip(d)
+			}
+		}
+		ignored, err := cfg.IsIgnored(path)
+		if err != nil {
+			cfg.Logger.Warn("Failed to check xattr", "path", path, "error", err)
+			return nil
+		}
+		if !ignored {
+			syncedNames[nameKey(d.Name(), d.IsDir())] = true
+			if !d.IsDir() {
+				syncedExts[filepath.Ext(d.Name())] = true
+			}
+			return nil
+		}
+
+		m := &marked{path: path, dir: d.IsDir(), reason: reasonAttribute}
+		if d.IsDir() {
+			m.size, m.files = matcher.DirSize(path)
+		} else if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				cfg.Logger.Warn("Failed to get info", "path", path, "error", err)
+				return nil
+			}
+			m.size, m.files = info.Size(), 1
+		}
+		found = append(found, m)
+		isMarked[path] = true
+		return skip(d)
+	}
+
+	p, err := poller.NewPoller(poller.Config{
+		Root:    root,
+		Handler: handler,
+		Logger:  slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Scan(); err != nil {
+		return nil, err
+	}
+
+	for _, path := range cfg.Excluded {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		path = filepath.Clean(path)
+		if isMarked[path] || !within(path, root) {
+			continue
+		}
+		if cfg.Matcher != nil {
+			if res, err := cfg.Matcher.Match(path, matcher.VirtualEntry(path+"/")); err == nil && res.Ignored {
+				continue
+			}
+		}
+		found = append(found, &marked{path: path, dir: true, reason: reasonExcluded})
+		isMarked[path] = true
+	}
+
+	suggestions := generalize(root, found, syncedNames, syncedExts)
+	sortSuggestions(suggestions)
+	return suggestions, nil
+}
+
+// generalize turns marked paths into rules, one per shared name or
+// extension where that covers nothing synced, one per path otherwise
+func generalize(root string, found []*marked, syncedNames, syncedExts map[string]bool) []Suggestion {
+	// Only the closest ignore file applies to a path, so paths are grouped
+	// by it first
+	byFile := make(map[string][]*marked)
+	var files []string
+	for _, m := range found {
+		file := closestIgnoreFile(filepath.Dir(m.path), root)
+		if _, ok := byFile[file]; !ok {
+			files = append(files, file)
+		}
+		byFile[file] = append(byFile[file], m)
+	}
+	sort.Strings(files)
+
+	var suggestions []Suggestion
+	for _, file := range files {
+		group := byFile[file]
+		done := make(map[*marked]bool)
+
+		byName := make(map[string][]*marked)
+		for _, m := range group {
+			key := nameKey(filepath.Base(m.path), m.dir)
+			byName[key] = append(byName[key], m)
+		}
+		for _, m := range group {
+			key := nameKey(filepath.Base(m.path), m.dir)
+			shared := byName[key]
+			// A rule without a slash covers directories of the name too
+			if done[m] || len(shared) < 2 || syncedNames[key] || !m.dir && syncedNames[key+"/"] {
+				continue
+			}
+			pattern := matcher.EscapeGlob(filepath.Base(m.path))
+			if m.dir {
+				pattern += "/"
+			}
+			suggestions = append(suggestions, merge(file, pattern, shared, reasonName, done))
+		}
+
+		byExt := make(map[string][]*marked)
+		for _, m := range group {
+			if ext := filepath.Ext(m.path); !done[m] && !m.dir && ext != "" {
+				byExt[ext] = append(byExt[ext], m)
+			}
+		}
+		for _, m := range group {
+			ext := filepath.Ext(m.path)
+			shared := byExt[ext]
+			if done[m] || len(shared) < 2 || syncedExts[ext] {
+				continue
+			}
+			suggestions = append(suggestions, merge(file, "*"+matcher.EscapeGlob(ext), shared, reasonExtension, done))
+		}
+
+		for _, m := range group {
+			if done[m] {
+				continue
+			}
+			rel, err := filepath.Rel(filepath.Dir(file), m.path)
+			if err != nil {
+				continue
+			}
+			pattern := "/" + matcher.EscapeGlob(filepath.ToSlash(rel))
+			if m.dir {
+				pattern += "/"
+			}
+			suggestions = append(suggestions, merge(file, pattern, []*marked{m}, "", done))
+		}
+	}
+	return suggestions
+}
+
+// merge builds one suggestion covering paths
+func merge(file, pattern string, paths []*marked, reason string, done map[*marked]bool) Suggestion {
+	s := Suggestion{File: file, Pattern: pattern}
+	for _, m := range paths {
+		done[m] = true
+		s.Paths = append(s.Paths, m.path)
+		s.Size += m.size
+		s.Files += m.files
+		if !slices.Contains(s.Reasons, m.reason) {
+			s.Reasons = append(s.Reasons, m.reason)
+		}
+	}
+	if reason != "" {
+		s.Reasons = append(s.Reasons, fmt.Sprintf("%s of %d paths", reason, len(paths)))
+	}
+	return s
+}
+
+// ParseExcludeList reads the output of dropbox exclude list, one excluded
+// directory per line after an "Excluded:" header
+func ParseExcludeList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "Excluded:"), strings.HasPrefix(line, "No directories"):
+			continue
+		}
+		paths = append(paths, strings.TrimSuffix(line, "/"))
+	}
+	return paths, scanner.Err()
+}
+
+// skip stops the walk from descending into ignored directories
+func skip(d fs.DirEntry) error {
+	if d.IsDir() {
+		return poller.ErrSkipDir
+	}
+	return nil
+}
+
+// nameKey distinguishes directories and files of the same name
+func nameKey(name string, dir bool) string {
+	if dir {
+		return name + "/"
+	}
+	return name
+}
+
+// within reports whether path is below root
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,150 @@
+package suggest
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+func TestAdopt(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{
+		"a/cache/x":          100,
+		"b/cache/y":          200,
+		"c/build/z":          300,
+		"d/build/keep":       10, // a synced build directory
+		"e/movie.iso":        1000,
+		"f/disk.iso":         2000,
+		"g/report.pdf":       5,
+		"h/notes.pdf":        5,
+		"old/.cache/blob":    50,
+		"logs/app.log":       7,
+		"sub/.dropboxignore": 0,
+		"sub/cache/w":        400,
+		".dropboxignore":     0,
+	})
+	if err := os.WriteFile(filepath.Join(root, ".dropboxignore"), []byte("*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	// Stand in for the attribute, which tmpfs may not support
+	marked := make(map[string]bool)
+	for _, name := range []string{"a/cache", "b/cache", "c/build", "e/movie.iso", "f/disk.iso", "g/report.pdf", "old/.cache", "logs/app.log", "sub/cache"} {
+		marked[filepath.Join(root, name)] = true
+	}
+	m, err := matcher.NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	suggestions, err := Adopt(AdoptConfig{
+		Root:      root,
+		Matcher:   m,
+		IsIgnored: func(path string) (bool, error) { return marked[path], nil },
+		Excluded:  []string{"photos/2019", filepath.Join(root, "a/cache"), "/elsewhere/x"},
+		Logger:    slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Adopt failed: %v", err)
+	}
+
+	got := make(map[string][]string)
+	for _, s := range suggestions {
+		rel, _ := filepath.Rel(root, s.File)
+		var paths []string
+		for _, p := range s.Paths {
+			p, _ = filepath.Rel(root, p)
+			paths = append(paths, p)
+		}
+		got[rel+" "+s.Pattern] = paths
+	}
+	expected := map[string][]string{
+		".dropboxignore cache/":        {"a/cache", "b/cache"},
+		".dropboxignore /c/build/":     {"c/build"},
+		".dropboxignore *.iso":         {"e/movie.iso", "f/disk.iso"},
+		".dropboxignore /g/report.pdf": {"g/report.pdf"},
+		".dropboxignore /old/.cache/":  {"old/.cache"},
+		".dropboxignore /photos/2019/": {"photos/2019"},
+		"sub/.dropboxignore /cache/":   {"sub/cache"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	// Largest first
+	if suggestions[0].Pattern != "*.iso" || suggestions[0].Size != 3000 {
+		t.Errorf("Expected *.iso first, got %+v", suggestions[0])
+	}
+}
+
+func TestParseExcludeList(t *testing.T) {
+	paths, err := ParseExcludeList(strings.NewReader("Excluded: \nPhotos/2019\n/home/me/Dropbox/Archive/\n\n"))
+	if err != nil {
+		t.Fatalf("ParseExcludeList failed: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"Photos/2019", "/home/me/Dropbox/Archive"}) {
+		t.Errorf("Unexpected paths %v", paths)
+	}
+	paths, _ = ParseExcludeList(strings.NewReader("No directories are being ignored.\n"))
+	if len(paths) != 0 {
+		t.Errorf("Expected no paths, got %v", paths)
+	}
+}
+
+func TestLinesWithoutSize(t *testing.T) {
+	s := Suggestion{Pattern: "/photos/2019/", Reasons: []string{reasonExcluded}}
+	if lines := s.Lines(); lines[0] != "# excluded from selective sync" {
+		t.Errorf("Unexpected lines %v", lines)
+	}
+}
+
+func TestAdoptAfterSection(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "box")
+	writeFiles(t, base, map[string]int{
+		"box/backup.iso": 100,
+		"box/notes.txt":  1,
+	})
+	// The governing file is above the root and ends in a section
+	governing := filepath.Join(base, ".dropboxignore")
+	if err := os.WriteFile(governing, []byte("*.log\n[os:plan9]\nscratch/\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	marked := filepath.Join(root, "backup.iso")
+
+	host := matcher.Host{OS: "linux"}
+	m, err := matcher.NewMatcherWithConfig(matcher.Config{Host: host})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	suggestions, err := Adopt(AdoptConfig{
+		Root:      root,
+		Matcher:   m,
+		IsIgnored: func(path string) (bool, error) { return path == marked, nil },
+		Logger:    slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("Adopt failed: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].File != governing || suggestions[0].Pattern != "/box/backup.iso" {
+		t.Fatalf("Expected /box/backup.iso in %s, got %+v", governing, suggestions)
+	}
+	if err := Append(suggestions); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(governing)
+	if !strings.HasPrefix(string(content), "*.log\n[os:plan9]\nscratch/\n[*]\n") {
+		t.Errorf("Expected the section to be closed, got %q", content)
+	}
+	fresh, err := matcher.NewMatcherWithConfig(matcher.Config{Host: host})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	if ignored, err := fresh.ShouldIgnore(marked); err != nil || !ignored {
+		t.Errorf("Expected the adopted rule to apply, got %v, %v", ignored, err)
+	}
+}
@@ -0,0 +1,147 @@
+package suggest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+	"github.com/gghcode/dropbox-ignore-daemon/internal/report"
+)
+
+// Lines renders a suggestion as it is added to its ignore file: a comment
+// with the estimated savings, when known, followed by the pattern
+func (s Suggestion) Lines() []string {
+	comment := "# " + strings.Join(s.Reasons, ", ")
+	if s.Files > 0 {
+		comment = fmt.Sprintf("# %s in %d files: %s", report.FormatBytes(s.Size), s.Files, strings.Join(s.Reasons, ", "))
+	}
+	return []string{comment, s.Pattern}
+}
+
+// Append adds suggestions to the end of their ignore files, creating them
+// as needed
+func Append(suggestions []Suggestion) error {
+	for _, file := range files(suggestions) {
+		old, err := readFile(file)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(strings.Join(added(old, suggestions, file), "\n") + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Patch renders suggestions as a unified diff against the ignore files,
+// with paths relative to root, for use with git apply or patch -p1
+func Patch(root string, suggestions []Suggestion) (string, error) {
+	var b strings.Builder
+	for _, file := range files(suggestions) {
+		old, err := readFile(file)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return "", err
+		}
+		rel = filepath.ToSlash(rel)
+
+		var oldLines []string
+		if old != "" {
+			oldLines = strings.Split(strings.TrimSuffix(old, "\n"), "\n")
+		}
+		newLines := added(old, suggestions, file)
+		// A missing final newline is fixed by the first added line
+		if old != "" && !strings.HasSuffix(old, "\n") {
+			newLines = newLines[1:]
+		}
+
+		if old == "" {
+			fmt.Fprintf(&b, "--- /dev/null\n+++ b/%s\n", rel)
+		} else {
+			fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", rel, rel)
+		}
+
+		// Up to three lines of context before the addition
+		context := oldLines[max(0, len(oldLines)-3):]
+		start := len(oldLines) - len(context) + 1
+		if len(context) == 0 {
+			start = 0
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(start, len(context)), hunkRange(max(start, 1), len(context)+len(newLines)))
+		for i, line := range context {
+			if i == len(context)-1 && !strings.HasSuffix(old, "\n") {
+				fmt.Fprintf(&b, "-%s\n\\ No newline at end of file\n+%s\n", line, line)
+				continue
+			}
+			fmt.Fprintf(&b, " %s\n", line)
+		}
+		for _, line := range newLines {
+			fmt.Fprintf(&b, "+%s\n", line)
+		}
+	}
+	return b.String(), nil
+}
+
+// hunkRange formats a unified diff range
+func hunkRange(start, n int) string {
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// added returns the lines to append to file, starting with an empty line
+// when the file lacks a final newline, and with [*] when the file ends in
+// a conditional section, which the rules would otherwise be limited to
+func added(old string, suggestions []Suggestion, file string) []string {
+	var lines []string
+	if old != "" && !strings.HasSuffix(old, "\n") {
+		lines = append(lines, "")
+	}
+	if matcher.SectionAtEnd(old) != "" {
+		lines = append(lines, "[*]")
+	}
+	for _, s := range suggestions {
+		if s.File == file {
+			lines = append(lines, s.Lines()...)
+		}
+	}
+	return lines
+}
+
+// files lists the ignore files suggestions go into, in order
+func files(suggestions []Suggestion) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, s := range suggestions {
+		if !seen[s.File] {
+			seen[s.File] = true
+			files = append(files, s.File)
+		}
+	}
+	return files
+}
+
+// readFile reads a file that may not exist yet
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return string(content), err
+}
@@ -0,0 +1,370 @@
+// Package suggest proposes .dropboxignore rules for large directories that
+// look like generated content and for paths already ignored without a rule
+package suggest
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+const (
+	// Default minimum size of a directory worth suggesting
+	defaultMinSize = 10 << 20
+	// Files below this size count as small
+	smallFileSize = 16 << 10
+	// Directories with at least this many files, mostly small, look generated
+	manySmallFiles = 1000
+	// Files modified within this window count as churn
+	churnWindow = 7 * 24 * time.Hour
+	// Directories compared against their sibling sources need this many files
+	minOutputFiles = 10
+
+	ignoreFileName = ".dropboxignore"
+)
+
+// cacheDirSignature starts every valid CACHEDIR.TAG, see
+// https://bford.info/cachedir/
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// generatedNames are directories that are generated wherever they appear,
+// so a single name rule covers every copy
+var generatedNames = map[string]bool{
+	"node_modules":     true,
+	"bower_components": true,
+	"__pycache__":      true,
+	".pytest_cache":    true,
+	".mypy_cache":      true,
+	".ruff_cache":      true,
+	".tox":             true,
+	".gradle":          true,
+	".next":            true,
+	".nuxt":            true,
+	".svelte-kit":      true,
+	".parcel-cache":    true,
+	".angular":         true,
+	".turbo":           true,
+	".dart_tool":       true,
+	".terraform":       true,
+	"DerivedData":      true,
+}
+
+// outputNames are build outputs only when a project file sits next to them
+var outputNames = map[string]bool{
+	"build":    true,
+	"dist":     true,
+	"out":      true,
+	"target":   true,
+	"bin":      true,
+	"obj":      true,
+	"coverage": true,
+}
+
+// projectFiles mark a directory as the root of a software project
+var projectFiles = map[string]bool{
+	"Makefile":         true,
+	"CMakeLists.txt":   true,
+	"package.json":     true,
+	"go.mod":           true,
+	"Cargo.toml":       true,
+	"pom.xml":          true,
+	"build.gradle":     true,
+	"build.gradle.kts": true,
+	"setup.py":         true,
+	"pyproject.toml":   true,
+	"meson.build":      true,
+}
+
+// projectExts mark a project by extension, e.g. Foo.csproj
+var projectExts = map[string]bool{
+	".csproj": true,
+	".fsproj": true,
+	".vbproj": true,
+	".sln":    true,
+}
+
+// Suggestion is a proposed .dropboxignore line
+type Suggestion struct {
+	// File is the closest .dropboxignore at or below the root, which the
+	// line belongs in; it may not exist yet
+	File    string
+	Pattern string
+	// Paths are the directories the pattern would ignore
+	Paths []string
+	// Size and Files are the estimated savings
+	Size    int64
+	Files   int
+	Reasons []string
+}
+
+// Config holds analyzer configuration
+type Config struct {
+	Root string
+	// Matcher skips content that is already ignored; optional
+	Matcher *matcher.Matcher
+	// MinSize is the smallest directory worth suggesting
+	MinSize int64
+	Logger  *slog.Logger
+}
+
+// dirNode summarizes a directory subtree
+type dirNode struct {
+	path     string
+	name     string
+	size     int64
+	files    int
+	small    int
+	recent   int
+	oldest   time.Time
+	newest   time.Time
+	children []*dirNode
+
+	// Direct contents
+	project     bool
+	cacheTag    bool
+	virtualenv  bool
+	filesNewest time.Time
+}
+
+// Analyze walks the root and returns suggestions, largest first
+func Analyze(cfg Config) ([]Suggestion, error) {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultMinSize
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &analyzer{cfg: cfg, now: time.Now()}
+	tree, err := a.build(root, filepath.Base(root))
+	if err != nil {
+		return nil, err
+	}
+
+	// Suggestions are keyed by file and pattern
+	byPattern := make(map[string]*Suggestion)
+	var visit func(n *dirNode)
+	visit = func(n *dirNode) {
+		for _, child := range n.children {
+			reasons := a.reasons(child, n)
+			if len(reasons) == 0 || child.size < cfg.MinSize {
+				visit(child)
+				continue
+			}
+
+			// Only the closest ignore file applies to a path
+			file := closestIgnoreFile(n.path, root)
+			pattern := child.name + "/"
+			if !generatedNames[child.name] {
+				rel, err := filepath.Rel(filepath.Dir(file), child.path)
+				if err != nil {
+					continue
+				}
+				pattern = "/" + matcher.EscapeGlob(filepath.ToSlash(rel)) + "/"
+			}
+			key := file + "\x00" + pattern
+			s, ok := byPattern[key]
+			if !ok {
+				s = &Suggestion{File: file, Pattern: pattern}
+				byPattern[key] = s
+			}
+			s.Paths = append(s.Paths, child.path)
+			s.Size += child.size
+			s.Files += child.files
+			for _, r := range reasons {
+				if !slices.Contains(s.Reasons, r) {
+					s.Reasons = append(s.Reasons, r)
+				}
+			}
+		}
+	}
+	visit(tree)
+
+	suggestions := make([]Suggestion, 0, len(byPattern))
+	for _, s := range byPattern {
+		suggestions = append(suggestions, *s)
+	}
+	sortSuggestions(suggestions)
+	return suggestions, nil
+}
+
+// sortSuggestions orders suggestions largest first
+func sortSuggestions(suggestions []Suggestion) {
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Size != suggestions[j].Size {
+			return suggestions[i].Size > suggestions[j].Size
+		}
+		if suggestions[i].File != suggestions[j].File {
+			return suggestions[i].File < suggestions[j].File
+		}
+		return suggestions[i].Pattern < suggestions[j].Pattern
+	})
+}
+
+// closestIgnoreFile returns the .dropboxignore governing entries of dir,
+// found the way the matcher finds it, or the root's when there is none
+func closestIgnoreFile(dir, root string) string {
+	if file := matcher.FindIgnoreFile(dir); file != "" {
+		return file
+	}
+	return filepath.Join(root, ignoreFileName)
+}
+
+type analyzer struct {
+	cfg Config
+	now time.Time
+}
+
+// build summarizes a directory, leaving out ignored content
+func (a *analyzer) build(path, name string) (*dirNode, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &dirNode{path: path, name: name}
+	for _, entry := range entries {
+		full := filepath.Join(path, entry.Name())
+		if entry.Type()&fs.ModeSymlink != 0 || entry.Name() == ".git" {
+			continue
+		}
+		if a.cfg.Matcher != nil {
+			if res, err := a.cfg.Matcher.Match(full, entry); err == nil && res.Ignored {
+				continue
+			}
+		}
+
+		if entry.IsDir() {
+			child, err := a.build(full, entry.Name())
+			if err != nil {
+				a.cfg.Logger.Warn("Failed to read directory", "path", full, "error", err)
+				continue
+			}
+			n.children = append(n.children, child)
+			n.size += child.size
+			n.files += child.files
+			n.small += child.small
+			n.recent += child.recent
+			n.oldest = earliest(n.oldest, child.oldest)
+			n.newest = latest(n.newest, child.newest)
+			continue
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		switch name := entry.Name(); {
+		case projectFiles[name] || projectExts[filepath.Ext(name)]:
+			n.project = true
+		case name == "CACHEDIR.TAG":
+			n.cacheTag = hasCacheDirSignature(full)
+		case name == "pyvenv.cfg":
+			n.virtualenv = true
+		}
+
+		mtime := info.ModTime()
+		n.size += info.Size()
+		n.files++
+		if info.Size() < smallFileSize {
+			n.small++
+		}
+		if a.now.Sub(mtime) < churnWindow {
+			n.recent++
+		}
+		n.oldest = earliest(n.oldest, mtime)
+		n.newest = latest(n.newest, mtime)
+		n.filesNewest = latest(n.filesNewest, mtime)
+	}
+	return n, nil
+}
+
+// reasons explains why a directory looks generated; none means it does not
+func (a *analyzer) reasons(n, parent *dirNode) []string {
+	var reasons []string
+	switch {
+	case generatedNames[n.name]:
+		reasons = append(reasons, "known generated directory")
+	case outputNames[n.name] && parent.project:
+		reasons = append(reasons, "build output next to a project file")
+	}
+	if n.cacheTag {
+		reasons = append(reasons, "contains CACHEDIR.TAG")
+	}
+	if n.virtualenv {
+		reasons = append(reasons, "Python virtual environment")
+	}
+	if parent.project && n.files >= minOutputFiles && n.oldest.After(siblingsNewest(n, parent)) {
+		reasons = append(reasons, "every file is younger than the sibling sources")
+	}
+	if len(reasons) == 0 {
+		// Many small, recently changed files only count together
+		if n.files >= manySmallFiles && n.small*10 >= n.files*9 && n.recent*2 >= n.files {
+			reasons = append(reasons, fmt.Sprintf("%d mostly small files, %d changed in the last week", n.files, n.recent))
+		}
+		return reasons
+	}
+	if n.files >= manySmallFiles && n.small*10 >= n.files*9 {
+		reasons = append(reasons, fmt.Sprintf("%d mostly small files", n.files))
+	}
+	return reasons
+}
+
+// siblingsNewest returns the newest modification time of everything in
+// parent except n
+func siblingsNewest(n, parent *dirNode) time.Time {
+	newest := parent.filesNewest
+	for _, sibling := range parent.children {
+		if sibling != n && !generatedNames[sibling.name] && !outputNames[sibling.name] {
+			newest = latest(newest, sibling.newest)
+		}
+	}
+	if newest.IsZero() {
+		// Without sources there is nothing to compare against
+		return n.oldest
+	}
+	return newest
+}
+
+// hasCacheDirSignature checks the first bytes of a CACHEDIR.TAG
+func hasCacheDirSignature(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := f.Read(buf); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, []byte(cacheDirSignature))
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,252 @@
+package suggest
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gghcode/dropbox-ignore-daemon/internal/matcher"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]int) {
+	t.Helper()
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{
+		"web/package.json":               10,
+		"web/node_modules/a/index.js":    600,
+		"api/node_modules/b/index.js":    600,
+		"tool/Makefile":                  10,
+		"tool/build/tool.o":              2000,
+		"photos/build/img.jpg":           2000, // no project file next to it
+		"cache/CACHEDIR.TAG":             0,
+		"docs/big.pdf":                   5000,
+		"small/node_modules/c/index.js":  10,
+		"vendored/node_modules/d/lib.js": 600,
+		".dropboxignore":                 0,
+	})
+	tag := cacheDirSignature + "\n# This file is a cache directory tag.\n"
+	if err := os.WriteFile(filepath.Join(root, "cache/CACHEDIR.TAG"), []byte(tag), 0644); err != nil {
+		t.Fatalf("Failed to write tag: %v", err)
+	}
+	writeFiles(t, root, map[string]int{"cache/blob": 1000})
+	// Already ignored content is not suggested again
+	if err := os.WriteFile(filepath.Join(root, ".dropboxignore"), []byte("/vendored/\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+
+	m, err := matcher.NewMatcher(10)
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	suggestions, err := Analyze(Config{Root: root, Matcher: m, MinSize: 500, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	ignoreFile := filepath.Join(root, ".dropboxignore")
+	expected := []struct {
+		pattern string
+		size    int64
+		paths   int
+	}{
+		{"/tool/build/", 2000, 1},
+		{"node_modules/", 1200, 2},
+		{"/cache/", 1000 + int64(len(tag)), 1},
+	}
+	if len(suggestions) != len(expected) {
+		t.Fatalf("Expected %d suggestions, got %+v", len(expected), suggestions)
+	}
+	for i, e := range expected {
+		s := suggestions[i]
+		if s.Pattern != e.pattern || s.Size != e.size || len(s.Paths) != e.paths || s.File != ignoreFile {
+			t.Errorf("Suggestion %d: expected %s with %d bytes in %d paths, got %+v", i, e.pattern, e.size, e.paths, s)
+		}
+	}
+}
+
+func TestAnalyzeNewerOutput(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{"proj/go.mod": 10, "proj/main.go": 10}
+	for i := range minOutputFiles {
+		files[filepath.Join("proj/gen", string(rune('a'+i))+".pb")] = 100
+	}
+	writeFiles(t, root, files)
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"proj/go.mod", "proj/main.go"} {
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+	}
+
+	suggestions, err := Analyze(Config{Root: root, MinSize: 1, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Pattern != "/proj/gen/" {
+		t.Fatalf("Expected /proj/gen/, got %+v", suggestions)
+	}
+	if !strings.Contains(strings.Join(suggestions[0].Reasons, ","), "younger") {
+		t.Errorf("Unexpected reasons %v", suggestions[0].Reasons)
+	}
+}
+
+func TestClosestIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{
+		"proj/.dropboxignore":             0,
+		"proj/sub/node_modules/x/y.js":    100,
+		"other/node_modules/x/y.js":       100,
+		"proj/sub/package.json":           1,
+		"proj/sub/dist/weird [name]/a.js": 100,
+		"proj/sub/dist/b.js":              100,
+		"proj/sub/dist/c.js":              100,
+	})
+
+	suggestions, err := Analyze(Config{Root: root, MinSize: 1, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, s := range suggestions {
+		rel, _ := filepath.Rel(root, s.File)
+		got[rel+" "+s.Pattern] = ""
+	}
+	for _, want := range []string{
+		"proj/.dropboxignore node_modules/",
+		".dropboxignore node_modules/",
+		"proj/.dropboxignore /sub/dist/",
+	} {
+		if _, ok := got[want]; !ok {
+			t.Errorf("Expected %q, got %v", want, got)
+		}
+	}
+}
+
+func TestPatchAndAppend(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, ".dropboxignore")
+	if err := os.WriteFile(existing, []byte("a\nb\nc\nd"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	fresh := filepath.Join(root, "sub", ".dropboxignore")
+	suggestions := []Suggestion{
+		{File: existing, Pattern: "node_modules/", Size: 2048, Files: 3, Reasons: []string{"known generated directory"}},
+		{File: fresh, Pattern: "/dist/", Size: 10, Files: 1, Reasons: []string{"contains CACHEDIR.TAG"}},
+	}
+
+	patch, err := Patch(root, suggestions)
+	if err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	expected := `--- a/.dropboxignore
++++ b/.dropboxignore
+@@ -2,3 +2,5 @@
+ b
+ c
+-d
+\ No newline at end of file
++d
++# 2.0 KiB in 3 files: known generated directory
++node_modules/
+--- /dev/null
++++ b/sub/.dropboxignore
+@@ -0,0 +1,2 @@
++# 10 B in 1 files: contains CACHEDIR.TAG
++/dist/
+`
+	if patch != expected {
+		t.Errorf("Unexpected patch:\n%s\nexpected:\n%s", patch, expected)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fresh), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := Append(suggestions); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	content, _ := os.ReadFile(existing)
+	if string(content) != "a\nb\nc\nd\n# 2.0 KiB in 3 files: known generated directory\nnode_modules/\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	content, _ = os.ReadFile(fresh)
+	if string(content) != "# 10 B in 1 files: contains CACHEDIR.TAG\n/dist/\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestClosestIgnoreFileAboveRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "Dropbox", "proj")
+	writeFiles(t, base, map[string]int{
+		".dropboxignore":                   0,
+		"Dropbox/proj/node_modules/x/y.js": 100,
+		"Dropbox/proj/node_modules/x/z.js": 100,
+		"Dropbox/proj/package.json":        1,
+	})
+
+	suggestions, err := Analyze(Config{Root: root, MinSize: 1, Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	governing := filepath.Join(base, ".dropboxignore")
+	if len(suggestions) != 1 || suggestions[0].File != governing {
+		t.Fatalf("Expected a suggestion for the governing %s, got %+v", governing, suggestions)
+	}
+
+	if hidden := matcher.HiddenRules(filepath.Join(root, ".dropboxignore")); hidden != governing {
+		t.Errorf("Expected a new file under the root to hide %s, got %q", governing, hidden)
+	}
+	if hidden := matcher.HiddenRules(governing); hidden != "" {
+		t.Errorf("Expected an existing file to hide nothing, got %q", hidden)
+	}
+}
+
+func TestAppendAfterSection(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, ".dropboxignore")
+	if err := os.WriteFile(file, []byte("*.log\n[os:plan9]\nscratch/"), 0644); err != nil {
+		t.Fatalf("Failed to create ignore file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	suggestions := []Suggestion{{File: file, Pattern: "/build/", Reasons: []string{"build output"}}}
+
+	patch, err := Patch(root, suggestions)
+	if err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if !strings.Contains(patch, "+scratch/\n+[*]\n+# build output\n+/build/\n") {
+		t.Errorf("Expected the patch to close the section, got:\n%s", patch)
+	}
+
+	if err := Append(suggestions); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != "*.log\n[os:plan9]\nscratch/\n[*]\n# build output\n/build/\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	m, err := matcher.NewMatcherWithConfig(matcher.Config{Host: matcher.Host{OS: "linux"}})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %v", err)
+	}
+	if ignored, err := m.ShouldIgnore(filepath.Join(root, "build")); err != nil || !ignored {
+		t.Errorf("Expected the appended rule to apply on every OS, got %v, %v", ignored, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package symlink decides how symbolic links under a root are matched and
+// marked, so that the watcher, the poller and the matcher treat them alike.
+package symlink
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policy is how symbolic links are handled
+type Policy string
+
+const (
+	// Follow matches and marks the target of a link, as long as it lies
+	// within the root. This is the default.
+	Follow Policy = "follow"
+	// Mark matches and marks the link itself, never its target. Linux
+	// does not allow user attributes on links, so marking fails there.
+	Mark Policy = "mark"
+	// Skip leaves links alone
+	Skip Policy = "skip"
+)
+
+// Policies lists the accepted policies
+var Policies = []Policy{Follow, Mark, Skip}
+
+var (
+	// ErrSkipped is returned for links under the Skip policy
+	ErrSkipped = errors.New("symlink skipped by policy")
+	// ErrEscapesRoot is returned for links whose target lies outside the
+	// root under the Follow policy
+	ErrEscapesRoot = errors.New("symlink target is outside the root")
+)
+
+// ParsePolicy parses follow, mark or skip
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range Policies {
+		if Policy(s) == p {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown symlink policy %q, expected follow, mark or skip", s)
+}
+
+// Resolver applies a policy to the links under a root. The zero value
+// follows links without checking where they lead.
+type Resolver struct {
+	Policy Policy
+	// Root is the directory links may not escape under Follow; empty
+	// disables the check
+	Root string
+}
+
+// Stat returns the file info a path is matched and marked with: that of
+// the path itself for anything but a link, that of the link under Mark,
+// and that of the target under Follow. Links under Skip and targets
+// outside the root return ErrSkipped and ErrEscapesRoot.
+func (r Resolver) Stat(path string) (fs.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return info, err
+	}
+	return r.Resolve(path, info)
+}
+
+// Resolve is Stat for a path already known to be a link, with its Lstat
+// info, for example from a directory listing
+func (r Resolver) Resolve(path string, link fs.FileInfo) (fs.FileInfo, error) {
+	switch r.Policy {
+	case Mark:
+		return link, nil
+	case Skip:
+		return nil, ErrSkipped
+	}
+	if r.Root != "" {
+		escapes, err := r.Escapes(path)
+		if err != nil {
+			return nil, err
+		}
+		if escapes {
+			return nil, ErrEscapesRoot
+		}
+	}
+	return os.Stat(path)
+}
+
+// NoFollow reports whether attributes are read and written on links
+// themselves
+func (r Resolver) NoFollow() bool {
+	return r.Policy == Mark
+}
+
+// Escapes reports whether path resolves to a location outside the root
+func (r Resolver) Escapes(path string) (bool, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	root, err := filepath.EvalSymlinks(r.Root)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return true, nil
+	}
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
@@ -0,0 +1,127 @@
+package symlink
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range Policies {
+		if got, err := ParsePolicy(string(p)); err != nil || got != p {
+			t.Errorf("ParsePolicy(%q) = %q, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePolicy("resolve"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// tree creates a root with a file, and links to it, to a directory outside
+// the root, back out through a relative path, and to nothing
+func tree(t *testing.T) (root, outside string) {
+	t.Helper()
+	outside = t.TempDir()
+	root = t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"inside":        "file",
+		"sub/up":        "../file",
+		"outside":       outside,
+		"sub/relescape": filepath.Join("..", "..", filepath.Base(outside)),
+		"broken":        "missing",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestStatFollow(t *testing.T) {
+	root, _ := tree(t)
+	r := Resolver{Policy: Follow, Root: root}
+
+	for _, name := range []string{"inside", "sub/up"} {
+		info, err := r.Stat(filepath.Join(root, name))
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("Expected %s to resolve to the file, got %v, %v", name, info, err)
+		}
+	}
+	for _, name := range []string{"outside", "sub/relescape"} {
+		if _, err := r.Stat(filepath.Join(root, name)); !errors.Is(err, ErrEscapesRoot) {
+			t.Errorf("Expected %s to escape the root, got %v", name, err)
+		}
+	}
+	if _, err := r.Stat(filepath.Join(root, "broken")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a broken link not to exist, got %v", err)
+	}
+	if info, err := r.Stat(filepath.Join(root, "sub")); err != nil || !info.IsDir() {
+		t.Errorf("Expected a directory to be returned as is, got %v, %v", info, err)
+	}
+}
+
+func TestStatMark(t *testing.T) {
+	root, _ := tree(t)
+	r := Resolver{Policy: Mark, Root: root}
+	for _, name := range []string{"inside", "outside", "broken"} {
+		info, err := r.Stat(filepath.Join(root, name))
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			t.Errorf("Expected %s to be returned as a link, got %v, %v", name, info, err)
+		}
+	}
+	if !r.NoFollow() {
+		t.Error("Expected the mark policy not to follow links")
+	}
+}
+
+func TestStatSkip(t *testing.T) {
+	root, _ := tree(t)
+	r := Resolver{Policy: Skip, Root: root}
+	if _, err := r.Stat(filepath.Join(root, "inside")); !errors.Is(err, ErrSkipped) {
+		t.Errorf("Expected the link to be skipped, got %v", err)
+	}
+	if info, err := r.Stat(filepath.Join(root, "file")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Expected a file to be returned as is, got %v, %v", info, err)
+	}
+}
+
+func TestStatZeroValue(t *testing.T) {
+	root, _ := tree(t)
+	// Without a root links are followed wherever they lead
+	info, err := Resolver{}.Stat(filepath.Join(root, "outside"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("Expected the link to be followed, got %v, %v", info, err)
+	}
+}
+
+func TestEscapesLinkedRoot(t *testing.T) {
+	base := t.TempDir()
+	realDir := filepath.Join(base, "real")
+	if err := os.Mkdir(realDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(realDir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The root is reached through a link, as with a relocated Dropbox
+	root := filepath.Join(base, "Dropbox")
+	if err := os.Symlink(realDir, root); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(realDir, "file"), filepath.Join(realDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	escapes, err := Resolver{Root: root}.Escapes(filepath.Join(root, "link"))
+	if err != nil || escapes {
+		t.Errorf("Expected a link within the resolved root not to escape, got %v, %v", escapes, err)
+	}
+}
@@ -3,7 +3,7 @@ package watcher
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -40,7 +40,7 @@ type Watcher struct {
 	mu      sync.Mutex
 	pending map[string]Event
 	
-	logger *log.Logger
+	logger *slog.Logger
 }
 
 // Config holds watcher configuration
@@ -48,7 +48,7 @@ type Config struct {
 	Handler       Handler
 	Debounce      time.Duration
 	FlushInterval time.Duration
-	Logger        *log.Logger
+	Logger        *slog.Logger
 }
 
 // NewWatcher creates a new filesystem watcher
@@ -65,7 +65,7 @@ func NewWatcher(cfg Config) (*Watcher, error) {
 		cfg.FlushInterval = defaultFlushInterval
 	}
 	if cfg.Logger == nil {
-		cfg.Logger = log.Default()
+		cfg.Logger = slog.Default()
 	}
 	
 	return &Watcher{
@@ -94,7 +94,7 @@ func (w *Watcher) AddRecursive(root string) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			// If we can't access the path, log and continue
-			w.logger.Printf("Cannot access %s: %v", path, err)
+			w.logger.Warn("Cannot access path", "path", path, "error", err)
 			return nil
 		}
 		
@@ -113,7 +113,7 @@ func (w *Watcher) AddRecursive(root string) error {
 		// Try to add watch, but don't fail entire walk on error
 		// This handles symlink directories that might point to non-existent paths
 		if err := w.Add(path); err != nil {
-			w.logger.Printf("Failed to watch %s: %v (continuing)", path, err)
+			w.logger.Warn("Failed to watch directory, continuing", "path", path, "error", err)
 			// Continue walking instead of returning error
 			return nil
 		}
@@ -142,7 +142,7 @@ func (w *Watcher) Run(ctx context.Context) error {
 			if !ok {
 				return nil
 			}
-			w.logger.Printf("Watch error: %v", err)
+			w.logger.Error("Watch error", "error", err)
 			
 		case <-flushTicker.C:
 			w.flushPending()
@@ -188,7 +188,7 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 			
 			// Try to add watch, handling potential errors for symlink directories
 			if err := w.Add(path); err != nil {
-				w.logger.Printf("Failed to watch new directory %s: %v", path, err)
+				w.logger.Warn("Failed to watch new directory", "path", path, "error", err)
 			}
 		}(event.Name)
 	}
@@ -214,11 +214,23 @@ func (w *Watcher) flushPending() {
 	// Process events synchronously (no goroutines)
 	for _, event := range toProcess {
 		if err := w.handler(event); err != nil {
-			w.logger.Printf("Handler error for %s: %v", event.Path, err)
+			w.logger.Warn("Handler error", "path", event.Path, "error", err)
 		}
 	}
 }
 
+// WatchCount returns the number of watched directories
+func (w *Watcher) WatchCount() int {
+	return len(w.watcher.WatchList())
+}
+
+// Pending returns the number of events waiting for their debounce period
+func (w *Watcher) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
 // Close stops watching and cleans up resources
 func (w *Watcher) Close() error {
 	return w.watcher.Close()
@@ -3,6 +3,8 @@
 package xattr
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
 
 	"golang.org/x/sys/unix"
@@ -11,35 +13,150 @@ import (
 const (
 	// macOS FileProvider attribute
 	attrMacOS = "com.apple.fileprovider.ignore#P"
-	// Linux Dropbox attribute
-	attrLinux = "com.dropbox.ignored"
+	// Linux Dropbox attribute, in the user namespace as attr(1) sets it
+	attrLinux = "user.com.dropbox.ignored"
+	// Linux attribute name used before the user namespace prefix
+	attrLinuxLegacy = "com.dropbox.ignored"
 	// Attribute value
 	attrValue = "1"
 )
 
+// ErrNeedsDirectoryIgnore is returned for paths that reject the attribute
+// because they are read-only. Ignoring a parent directory instead avoids
+// writing to them.
+var ErrNeedsDirectoryIgnore = errors.New("needs directory-level ignore")
+
+// System calls, replaced in tests
+var (
+	setxattr     = unix.Setxattr
+	lsetxattr    = unix.Lsetxattr
+	removexattr  = unix.Removexattr
+	lremovexattr = unix.Lremovexattr
+	chmod        = unix.Chmod
+)
+
+// Marker reads and writes the ignore attribute. The zero value behaves like
+// the package-level functions.
+type Marker struct {
+	// MakeWritable briefly adds owner write permission to read-only paths
+	// that reject the attribute, restoring their exact mode afterwards
+	MakeWritable bool
+	// NoFollow reads and writes the attribute of symbolic links
+	// themselves rather than of their targets
+	NoFollow bool
+}
+
 // SetIgnored sets the appropriate extended attribute to mark a file/directory
 // as ignored by Dropbox.
 func SetIgnored(path string) error {
-	name := getAttrName()
-	return unix.Setxattr(path, name, []byte(attrValue), 0)
+	return Marker{}.SetIgnored(path)
 }
 
 // IsIgnored checks if a file/directory has the Dropbox ignore attribute set.
 func IsIgnored(path string) (bool, error) {
+	return Marker{}.IsIgnored(path)
+}
+
+// RemoveIgnored removes the Dropbox ignore attribute from a file/directory.
+func RemoveIgnored(path string) error {
+	return Marker{}.RemoveIgnored(path)
+}
+
+// SetIgnored sets the ignore attribute on path
+func (mk Marker) SetIgnored(path string) error {
 	name := getAttrName()
-	sz, err := unix.Getxattr(path, name, nil)
+	err := mk.write(path, func() error {
+		if mk.NoFollow {
+			return lsetxattr(path, name, []byte(attrValue), 0)
+		}
+		return setxattr(path, name, []byte(attrValue), 0)
+	})
+	if err == nil {
+		mk.removeLegacy(path)
+	}
+	return err
+}
+
+// IsIgnored checks if path has the ignore attribute set
+func (mk Marker) IsIgnored(path string) (bool, error) {
+	name := getAttrName()
+	getxattr := unix.Getxattr
+	if mk.NoFollow {
+		getxattr = unix.Lgetxattr
+	}
+	sz, err := getxattr(path, name, nil)
 	if isNoAttrError(err) {
 		return false, nil
 	}
 	return sz >= 0 && err == nil, err
 }
 
-// RemoveIgnored removes the Dropbox ignore attribute from a file/directory.
-func RemoveIgnored(path string) error {
+// RemoveIgnored removes the ignore attribute from path
+func (mk Marker) RemoveIgnored(path string) error {
 	name := getAttrName()
-	err := unix.Removexattr(path, name)
+	err := mk.write(path, func() error {
+		if mk.NoFollow {
+			return lremovexattr(path, name)
+		}
+		return removexattr(path, name)
+	})
 	if isNoAttrError(err) {
-		return nil
+		err = nil
+	}
+	if err == nil {
+		mk.removeLegacy(path)
+	}
+	return err
+}
+
+// removeLegacy drops the Linux attribute under its name from before the
+// user namespace prefix, so that marks migrate as paths are set or
+// cleared. Linux rejects the bare name on most filesystems, so the
+// result is not checked.
+func (mk Marker) removeLegacy(path string) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if mk.NoFollow {
+		lremovexattr(path, attrLinuxLegacy)
+		return
+	}
+	removexattr(path, attrLinuxLegacy)
+}
+
+// write runs an attribute change. On Linux, user attributes need write
+// permission, so read-only paths fail with EACCES; with MakeWritable the
+// change is retried with owner write permission added.
+func (mk Marker) write(path string, op func() error) error {
+	err := op()
+	if !errors.Is(err, unix.EACCES) {
+		return err
+	}
+	var st unix.Stat_t
+	stat := unix.Stat
+	if mk.NoFollow {
+		stat = unix.Lstat
+	}
+	if serr := stat(path, &st); serr != nil || st.Mode&unix.S_IWUSR != 0 || st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		// Not a read-only path, so write permission is not the problem.
+		// The mode of a link cannot be changed.
+		return err
+	}
+	if !mk.MakeWritable {
+		return fmt.Errorf("%w: %w", ErrNeedsDirectoryIgnore, err)
+	}
+
+	mode := uint32(st.Mode & 0o7777)
+	if cerr := chmod(path, mode|unix.S_IWUSR); cerr != nil {
+		// Only the owner can change the mode
+		return fmt.Errorf("%w: %w", ErrNeedsDirectoryIgnore, err)
+	}
+	err = op()
+	if cerr := chmod(path, mode); cerr != nil {
+		return fmt.Errorf("failed to restore mode %#o of %s: %w", mode, path, cerr)
+	}
+	if errors.Is(err, unix.EACCES) {
+		return fmt.Errorf("%w: %w", ErrNeedsDirectoryIgnore, err)
 	}
 	return err
 }
@@ -50,4 +167,4 @@ func getAttrName() string {
 		return attrMacOS
 	}
 	return attrLinux
-}
\ No newline at end of file
+}
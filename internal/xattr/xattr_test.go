@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
 	"syscall"
 	"testing"
 )
@@ -40,6 +41,34 @@ func testXattrSupport(t *testing.T) {
 	}
 }
 
+// TestLegacyAttributeName tests that setting and clearing the attribute
+// also drops it under its name from before the user namespace prefix
+func TestLegacyAttributeName(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux attribute names only")
+	}
+	origSet, origRemove := setxattr, removexattr
+	t.Cleanup(func() { setxattr, removexattr = origSet, origRemove })
+	
+	var removed []string
+	setxattr = func(string, string, []byte, int) error { return nil }
+	removexattr = func(path, attr string) error {
+		removed = append(removed, attr)
+		return nil
+	}
+	
+	if err := SetIgnored("file"); err != nil {
+		t.Fatalf("SetIgnored failed: %v", err)
+	}
+	if err := RemoveIgnored("file"); err != nil {
+		t.Fatalf("RemoveIgnored failed: %v", err)
+	}
+	expected := []string{attrLinuxLegacy, attrLinux, attrLinuxLegacy}
+	if len(removed) != len(expected) || removed[0] != expected[0] || removed[1] != expected[1] || removed[2] != expected[2] {
+		t.Errorf("Expected removals %v, got %v", expected, removed)
+	}
+}
+
 func TestSetGetRemoveIgnored(t *testing.T) {
 	testXattrSupport(t)
 	
@@ -230,4 +259,155 @@ func TestBrokenSymbolicLinks(t *testing.T) {
 	if ignored {
 		t.Error("Broken symlink should not report as ignored")
 	}
-}
\ No newline at end of file
+}
+
+// denyReadOnly makes attribute writes fail with EACCES on paths without
+// owner write permission, as they do for users other than root
+func denyReadOnly(t *testing.T) {
+	origSet, origRemove := setxattr, removexattr
+	t.Cleanup(func() { setxattr, removexattr = origSet, origRemove })
+	
+	readOnly := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().Perm()&0200 == 0
+	}
+	setxattr = func(path, attr string, data []byte, flags int) error {
+		if readOnly(path) {
+			return syscall.EACCES
+		}
+		return origSet(path, attr, data, flags)
+	}
+	removexattr = func(path, attr string) error {
+		if readOnly(path) {
+			return syscall.EACCES
+		}
+		return origRemove(path, attr)
+	}
+}
+
+func TestReadOnlyFile(t *testing.T) {
+	testXattrSupport(t)
+	denyReadOnly(t)
+	
+	path := filepath.Join(t.TempDir(), "pack")
+	if err := os.WriteFile(path, []byte("test"), 0444); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	
+	// Without the policy the file is reported
+	err := SetIgnored(path)
+	if !errors.Is(err, ErrNeedsDirectoryIgnore) || !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("Expected ErrNeedsDirectoryIgnore wrapping EACCES, got %v", err)
+	}
+	
+	// With it the attribute is set and the mode restored
+	mk := Marker{MakeWritable: true}
+	if err := mk.SetIgnored(path); err != nil {
+		t.Fatalf("SetIgnored failed: %v", err)
+	}
+	if ignored, err := mk.IsIgnored(path); err != nil || !ignored {
+		t.Errorf("Expected the file to be ignored, got %v, %v", ignored, err)
+	}
+	if err := mk.RemoveIgnored(path); err != nil {
+		t.Fatalf("RemoveIgnored failed: %v", err)
+	}
+	if ignored, _ := mk.IsIgnored(path); ignored {
+		t.Error("Expected the attribute to be removed")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("Expected mode 0444 to be restored, got %#o", info.Mode().Perm())
+	}
+}
+
+func TestReadOnlyFileSpecialBits(t *testing.T) {
+	testXattrSupport(t)
+	denyReadOnly(t)
+	
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, []byte("test"), 0555); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chmod(path, 0555|os.ModeSetgid|os.ModeSticky); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	
+	if err := (Marker{MakeWritable: true}).SetIgnored(path); err != nil {
+		t.Fatalf("SetIgnored failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if expected := 0555 | os.ModeSetgid | os.ModeSticky; info.Mode()&(os.ModePerm|os.ModeSetgid|os.ModeSticky) != expected {
+		t.Errorf("Expected mode %v to be restored, got %v", expected, info.Mode())
+	}
+}
+
+func TestReadOnlyNotOwner(t *testing.T) {
+	testXattrSupport(t)
+	denyReadOnly(t)
+	origChmod := chmod
+	t.Cleanup(func() { chmod = origChmod })
+	chmod = func(string, uint32) error { return syscall.EPERM }
+	
+	path := filepath.Join(t.TempDir(), "foreign")
+	if err := os.WriteFile(path, []byte("test"), 0444); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	err := (Marker{MakeWritable: true}).SetIgnored(path)
+	if !errors.Is(err, ErrNeedsDirectoryIgnore) {
+		t.Errorf("Expected ErrNeedsDirectoryIgnore when the mode cannot be changed, got %v", err)
+	}
+}
+
+func TestWritableFileAccessDenied(t *testing.T) {
+	testXattrSupport(t)
+	origSet := setxattr
+	t.Cleanup(func() { setxattr = origSet })
+	setxattr = func(string, string, []byte, int) error { return syscall.EACCES }
+	
+	path := filepath.Join(t.TempDir(), "writable")
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	err := (Marker{MakeWritable: true}).SetIgnored(path)
+	if !errors.Is(err, syscall.EACCES) || errors.Is(err, ErrNeedsDirectoryIgnore) {
+		t.Errorf("Expected a plain EACCES for a writable file, got %v", err)
+	}
+}
+
+func TestNoFollow(t *testing.T) {
+	testXattrSupport(t)
+	
+	tmpDir := t.TempDir()
+	realFile := filepath.Join(tmpDir, "real.txt")
+	if err := os.WriteFile(realFile, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create real file: %v", err)
+	}
+	linkFile := filepath.Join(tmpDir, "link.txt")
+	if err := os.Symlink(realFile, linkFile); err != nil {
+		t.Fatalf("Failed to create file symlink: %v", err)
+	}
+	
+	mk := Marker{NoFollow: true}
+	err := mk.SetIgnored(linkFile)
+	if runtime.GOOS == "linux" {
+		// Linux only allows user attributes on regular files and directories
+		if !errors.Is(err, syscall.EPERM) {
+			t.Errorf("Expected EPERM for a link on Linux, got %v", err)
+		}
+	} else if err != nil {
+		t.Fatalf("SetIgnored on link failed: %v", err)
+	} else if ignored, err := mk.IsIgnored(linkFile); err != nil || !ignored {
+		t.Errorf("Expected the link to be ignored, got %v, %v", ignored, err)
+	}
+	
+	// Either way the target is left alone
+	if ignored, err := IsIgnored(realFile); err != nil || ignored {
+		t.Errorf("Expected the target not to be ignored, got %v, %v", ignored, err)
+	}
+}